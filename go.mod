@@ -2,9 +2,14 @@ module Zippy
 
 go 1.24.4
 
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/term v0.32.0
+)
+
 require (
 	github.com/fatih/color v1.18.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.25.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
 )
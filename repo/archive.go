@@ -0,0 +1,209 @@
+package repo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveEntry is a regular file read from a zip or tar archive
+type archiveEntry struct {
+	name     string
+	mode     fs.FileMode
+	modified time.Time
+	body     io.Reader
+}
+
+// walkArchive calls fn for each regular file in a zip, tar, tar.gz or
+// tar.zst archive, telling the formats apart by their first bytes
+func walkArchive(path string, fn func(e archiveEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(magic, []byte("PK")) {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %v", zf.Name, err)
+			}
+			err = fn(archiveEntry{name: zf.Name, mode: zf.Mode(), modified: zf.Modified, body: rc})
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = bufio.NewReader(f)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zd, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zd.Close()
+		r = zd
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("not a zip or tar archive: %v", err)
+		}
+		info := header.FileInfo()
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := fn(archiveEntry{name: header.Name, mode: info.Mode(), modified: header.ModTime, body: tr}); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveTag names a version after an archive file, so
+// project-1.1.tar.gz becomes project-1.1
+func archiveTag(path string) string {
+	name := filepath.Base(path)
+	for _, ext := range []string{".gz", ".tgz", ".zst", ".tzst", ".tar", ".zip"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// ImportArchive creates a version from a zip or tarball. With strip the
+// leading directory of every entry is dropped, as release archives usually
+// wrap everything in project-1.0/. The version is dated by the newest entry.
+func (zippy *Repo) ImportArchive(path string, tag string, message string, strip bool) error {
+	if tag == "" {
+		tag = archiveTag(path)
+	}
+	if message == "" {
+		message = "Imported from " + filepath.Base(path)
+	}
+	if err := zippy.checkCompression(); err != nil {
+		return err
+	}
+	if err := validTag(tag); err != nil {
+		return invalidError("%v", err)
+	}
+	if _, err := zippy.loadVersion(tag); err == nil {
+		return conflictError("version %s already exists", tag)
+	}
+
+	tmp, err := os.CreateTemp("", "zippy_archive_*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zippyignore := zippy.loadZippyIgnore()
+	writer := zippy.newZipWriter(tmp, zippy.compressionLevel())
+	count := 0
+	var newest time.Time
+	err = walkArchive(path, func(e archiveEntry) error {
+		name := filepath.ToSlash(filepath.Clean(filepath.FromSlash(e.name)))
+		if strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+			zippy.logf("  [Skipped unsafe path: %s]\n", e.name)
+			return nil
+		}
+		if strip {
+			_, rest, ok := strings.Cut(name, "/")
+			if !ok {
+				return fmt.Errorf("cannot strip %s: it is not inside a directory", e.name)
+			}
+			name = rest
+		}
+		if zippyignore.shouldIgnore(name) {
+			return nil
+		}
+		header := entryHeader(name, nil, zippy.compressionMethod())
+		header.Modified = e.modified
+		header.SetMode(e.mode.Perm())
+		out, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, e.body); err != nil {
+			return fmt.Errorf("%s: %v", e.name, err)
+		}
+		if e.modified.After(newest) {
+			newest = e.modified
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%s has no files to import", path)
+	}
+	if newest.IsZero() {
+		newest = time.Now()
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	author, email := zippy.Identity()
+	v := Version{
+		Tag:         tag,
+		Message:     message,
+		Timestamp:   newest,
+		Author:      author,
+		AuthorEmail: email,
+		FilesCount:  count,
+	}
+	local := &localRemote{zippy: zippy}
+	if err := local.Store(v, tmp); err != nil {
+		return fmt.Errorf("failed to import %s: %w", path, err)
+	}
+	zippy.logf("Imported %s as %s (%d files, dated %s)\n", filepath.Base(path), tag, count, newest.Format("2006-01-02 15:04:05"))
+	return nil
+}
@@ -0,0 +1,327 @@
+package repo
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bundleMagic starts every bundle file. It is followed by the length of
+// the JSON header as a big-endian uint32, the header, its SHA-256 and then
+// the archives of the versions back to back, in header order.
+var bundleMagic = []byte("ZIPPYBDL")
+
+// bundleMaxHeader caps the header length read from a bundle, so a corrupt
+// one can't make openBundle allocate gigabytes
+const bundleMaxHeader = 64 << 20
+
+// bundleHeader lists the versions in a bundle
+type bundleHeader struct {
+	Format   int           `json:"format"`
+	Created  time.Time     `json:"created"`
+	Repo     RepoConfig    `json:"repo"`
+	Versions []bundleEntry `json:"versions"`
+}
+
+// bundleEntry is a version in a bundle and the checksum of its archive
+type bundleEntry struct {
+	Version Version `json:"version"`
+	Size    int64   `json:"size"`
+	SHA256  string  `json:"sha256"`
+}
+
+// selectVersions picks versions by tag or by range: "v1..v5" includes both
+// ends, "v3.." runs to the newest and "..v2" from the oldest. versions must
+// be sorted oldest first. No selectors selects everything.
+func selectVersions(versions []Version, selectors []string) ([]Version, error) {
+	if len(selectors) == 0 {
+		return versions, nil
+	}
+	index := map[string]int{}
+	for i, v := range versions {
+		index[v.Tag] = i
+	}
+	find := func(tag string, fallback int) (int, error) {
+		if tag == "" {
+			return fallback, nil
+		}
+		if i, ok := index[tag]; ok {
+			return i, nil
+		}
+		return 0, fmt.Errorf("version %s not found", tag)
+	}
+	picked := map[int]bool{}
+	for _, sel := range selectors {
+		if i, ok := index[sel]; ok {
+			picked[i] = true
+			continue
+		}
+		from, to, isRange := strings.Cut(sel, "..")
+		if !isRange {
+			return nil, fmt.Errorf("version %s not found", sel)
+		}
+		start, err := find(from, 0)
+		if err != nil {
+			return nil, err
+		}
+		end, err := find(to, len(versions)-1)
+		if err != nil {
+			return nil, err
+		}
+		if start > end {
+			return nil, fmt.Errorf("empty range %s: %s is newer than %s", sel, from, to)
+		}
+		for i := start; i <= end; i++ {
+			picked[i] = true
+		}
+	}
+	selected := []Version{}
+	for i, v := range versions {
+		if picked[i] {
+			selected = append(selected, v)
+		}
+	}
+	return selected, nil
+}
+
+// CreateBundle writes the selected versions and their archives to a single
+// file that 'zippy bundle import' can merge into another repository
+func (zippy *Repo) CreateBundle(out string, selectors []string) error {
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return err
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Timestamp.Before(versions[j].Timestamp)
+	})
+	selected, err := selectVersions(versions, selectors)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		zippy.logf("No versions to bundle.\n")
+		return nil
+	}
+
+	// Archives go to a temp file first, since the header that precedes
+	// them needs their sizes and checksums
+	data, err := os.CreateTemp("", "zippy_bundle_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+	info := sharedConfig(zippy.config)
+	info.Created = zippy.config.Created
+	info.Encryption = nil
+	header := bundleHeader{Format: 1, Created: time.Now(), Repo: info}
+	for _, v := range selected {
+		archive, err := zippy.readArchive(v)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", v.Tag, err)
+		}
+		h := sha256.New()
+		size, err := io.Copy(io.MultiWriter(data, h), archive)
+		archive.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", v.Tag, err)
+		}
+		header.Versions = append(header.Versions, bundleEntry{Version: publicVersion(v), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+		zippy.logf("  Bundled: %s (%s)\n", v.Tag, FormatSize(size))
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	headerData, _ := json.Marshal(header)
+	sum := sha256.Sum256(headerData)
+	var prefix bytes.Buffer
+	prefix.Write(bundleMagic)
+	binary.Write(&prefix, binary.BigEndian, uint32(len(headerData)))
+	prefix.Write(headerData)
+	prefix.Write(sum[:])
+	if err := writeFileAtomic(out, io.MultiReader(&prefix, data)); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	zippy.logf("Created bundle %s with %d versions.\n", out, len(selected))
+	if zippy.config.Encryption != nil {
+		zippy.logf("Note: bundles are not encrypted. Protect the file in transit.\n")
+	}
+	return nil
+}
+
+// bundleRemote reads versions from a bundle file. It can be used wherever a
+// remote is expected, e.g. 'zippy pull backup.zbundle'.
+type bundleRemote struct {
+	path    string
+	header  bundleHeader
+	offsets []int64 // file offset of each archive
+}
+
+// openBundle reads and checks the header of a bundle. The archives are
+// checked as they are read.
+func openBundle(path string) (*bundleRemote, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(bundleMagic))
+	var length uint32
+	if _, err := io.ReadFull(file, magic); err != nil || !bytes.Equal(magic, bundleMagic) {
+		return nil, fmt.Errorf("%s is not a Zippy bundle", path)
+	}
+	if err := binary.Read(file, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("%s: truncated header", path)
+	}
+	if length > bundleMaxHeader || int64(len(bundleMagic)+4)+int64(length)+sha256.Size > info.Size() {
+		return nil, fmt.Errorf("%s: truncated header", path)
+	}
+	headerData := make([]byte, int(length)+sha256.Size)
+	if _, err := io.ReadFull(file, headerData); err != nil {
+		return nil, fmt.Errorf("%s: truncated header", path)
+	}
+	sum := sha256.Sum256(headerData[:length])
+	if !bytes.Equal(sum[:], headerData[length:]) {
+		return nil, fmt.Errorf("%s: header checksum mismatch", path)
+	}
+	b := &bundleRemote{path: path}
+	if err := json.Unmarshal(headerData[:length], &b.header); err != nil {
+		return nil, fmt.Errorf("%s: corrupt header: %v", path, err)
+	}
+	if b.header.Format != 1 {
+		return nil, fmt.Errorf("%s: unsupported bundle format %d", path, b.header.Format)
+	}
+	offset := int64(len(bundleMagic)+4) + int64(len(headerData))
+	for _, e := range b.header.Versions {
+		if e.Size < 0 || e.Size > info.Size()-offset {
+			return nil, fmt.Errorf("%s: truncated at %s", path, e.Version.Tag)
+		}
+		b.offsets = append(b.offsets, offset)
+		offset += e.Size
+	}
+	return b, nil
+}
+
+func (b *bundleRemote) Config() (RepoConfig, error) {
+	return b.header.Repo, nil
+}
+
+func (b *bundleRemote) Versions() ([]Version, error) {
+	versions := []Version{}
+	for _, e := range b.header.Versions {
+		versions = append(versions, e.Version)
+	}
+	return versions, nil
+}
+
+// Fetch returns the archive of a version. Reading it to the end fails if
+// it doesn't match the checksum in the header.
+func (b *bundleRemote) Fetch(v Version) (io.ReadCloser, error) {
+	for i, e := range b.header.Versions {
+		if e.Version.Tag != v.Tag {
+			continue
+		}
+		file, err := os.Open(b.path)
+		if err != nil {
+			return nil, err
+		}
+		r := &checksumReader{r: io.NewSectionReader(file, b.offsets[i], e.Size), h: sha256.New(), want: e.SHA256}
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, file}, nil
+	}
+	return nil, fmt.Errorf("version %s not in bundle: %w", v.Tag, fs.ErrNotExist)
+}
+
+func (b *bundleRemote) Store(v Version, archive io.Reader) error {
+	return fmt.Errorf("bundles are read-only; use 'zippy bundle create'")
+}
+
+// checksumReader fails at EOF if the data read doesn't have the expected
+// SHA-256
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(c.h.Sum(nil)) != c.want {
+		return n, fmt.Errorf("checksum mismatch, the bundle is corrupt")
+	}
+	return n, err
+}
+
+// VerifyBundle checks the header and every archive of a bundle, writing
+// the result of each version to log
+func VerifyBundle(path string, log io.Writer) error {
+	b, err := openBundle(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	logf(log, "Bundle of %s, created %s\n", b.header.Repo.Name, b.header.Created.Format("2006-01-02 15:04:05"))
+	failed := 0
+	for i, e := range b.header.Versions {
+		archive, err := b.Fetch(e.Version)
+		if err == nil {
+			_, err = io.Copy(io.Discard, archive)
+			archive.Close()
+		}
+		if err == nil {
+			// Checking the zip structure as well catches archives that
+			// were corrupt before they were bundled
+			_, err = zip.NewReader(io.NewSectionReader(file, b.offsets[i], e.Size), e.Size)
+		}
+		if err != nil {
+			failed++
+			logf(log, "  FAILED %s: %v\n", e.Version.Tag, err)
+			continue
+		}
+		logf(log, "  OK     %s | %s | %s\n", e.Version.Tag, e.Version.Timestamp.Format("2006-01-02 15:04:05"), FormatSize(e.Size))
+	}
+	logf(log, "Verified %d versions, %d failed.\n", len(b.header.Versions), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d versions in the bundle are damaged", failed, len(b.header.Versions))
+	}
+	return nil
+}
+
+// ImportBundle merges the versions of a bundle into this repository,
+// skipping those it already has
+func (zippy *Repo) ImportBundle(path string) error {
+	b, err := openBundle(path)
+	if err != nil {
+		return err
+	}
+	zippy.logf("Importing %s...\n", path)
+	imported, err := zippy.transfer(b, &localRemote{zippy: zippy}, nil, "Imported")
+	if err != nil {
+		return err
+	}
+	zippy.logf("Import complete. %d new versions, %d already present.\n", imported, len(b.header.Versions)-imported)
+	return nil
+}
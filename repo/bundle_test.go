@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSelectVersions(t *testing.T) {
+	versions := []Version{{Tag: "v1"}, {Tag: "v2"}, {Tag: "v3"}, {Tag: "v4"}}
+	tests := []struct {
+		selectors []string
+		want      string // tags joined by spaces, or "error"
+	}{
+		{nil, "v1 v2 v3 v4"},
+		{[]string{"v2"}, "v2"},
+		{[]string{"v3", "v1"}, "v1 v3"},
+		{[]string{"v2..v3"}, "v2 v3"},
+		{[]string{"v3.."}, "v3 v4"},
+		{[]string{"..v2"}, "v1 v2"},
+		{[]string{".."}, "v1 v2 v3 v4"},
+		{[]string{"v1..v2", "v2..v3"}, "v1 v2 v3"},
+		{[]string{"v3..v3"}, "v3"},
+		{[]string{"v3..v2"}, "error"},
+		{[]string{"v9"}, "error"},
+		{[]string{"v1..v9"}, "error"},
+	}
+	for _, tt := range tests {
+		selected, err := selectVersions(versions, tt.selectors)
+		got := "error"
+		if err == nil {
+			tags := []string{}
+			for _, v := range selected {
+				tags = append(tags, v.Tag)
+			}
+			got = strings.Join(tags, " ")
+		}
+		if got != tt.want {
+			t.Errorf("selectVersions(%q) = %s (%v), want %s", tt.selectors, got, err, tt.want)
+		}
+	}
+}
+
+// readTestFile returns the contents of name in version tag
+func readTestFile(t *testing.T, zippy *Repo, tag string, name string) string {
+	t.Helper()
+	rc, err := zippy.OpenFile(tag, name)
+	if err != nil {
+		t.Fatalf("OpenFile %s %s: %v", tag, name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %s %s: %v", tag, name, err)
+	}
+	return string(data)
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	src := newTestRepo(t)
+	storeVersion(t, src, "v1", zipArchive(t, [2]string{"a.txt", "first"}))
+	storeVersion(t, src, "v2", zipArchive(t, [2]string{"a.txt", "second"}, [2]string{"b/c.txt", "c"}))
+	bundle := filepath.Join(t.TempDir(), "backup.zbundle")
+	if err := src.CreateBundle(bundle, nil); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	if err := VerifyBundle(bundle, io.Discard); err != nil {
+		t.Fatalf("VerifyBundle: %v", err)
+	}
+
+	dst, err := Init(t.TempDir(), InitOptions{Log: io.Discard})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	// The second import finds every version already present
+	for range 2 {
+		if err := dst.ImportBundle(bundle); err != nil {
+			t.Fatalf("ImportBundle: %v", err)
+		}
+	}
+	tags, _ := dst.Tags()
+	slices.Sort(tags)
+	if !slices.Equal(tags, []string{"v1", "v2"}) {
+		t.Fatalf("tags after import = %v", tags)
+	}
+	if got := readTestFile(t, dst, "v2", "b/c.txt"); got != "c" {
+		t.Errorf("v2 b/c.txt = %q, want %q", got, "c")
+	}
+}
+
+func TestBundleRejectsDamage(t *testing.T) {
+	src := newTestRepo(t)
+	storeVersion(t, src, "v1", zipArchive(t, [2]string{"a.txt", strings.Repeat("data ", 100)}))
+	good := filepath.Join(t.TempDir(), "good.zbundle")
+	if err := src.CreateBundle(good, nil); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	data, _ := os.ReadFile(good)
+	headerEnd := len(bundleMagic) + 4 + int(binary.BigEndian.Uint32(data[len(bundleMagic):])) + 32
+
+	tests := []struct {
+		name   string
+		damage func([]byte) []byte
+	}{
+		{"not a bundle", func(b []byte) []byte { return []byte("PK\x03\x04") }},
+		{"huge header length", func(b []byte) []byte { binary.BigEndian.PutUint32(b[len(bundleMagic):], 0xffffffff); return b }},
+		{"truncated header", func(b []byte) []byte { return b[:headerEnd-1] }},
+		{"header checksum", func(b []byte) []byte { b[headerEnd-1] ^= 1; return b }},
+		{"truncated archive", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"flipped archive byte", func(b []byte) []byte { b[headerEnd+40] ^= 1; return b }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := filepath.Join(t.TempDir(), "bad.zbundle")
+			os.WriteFile(bundle, tt.damage(bytes.Clone(data)), 0644)
+			if err := VerifyBundle(bundle, io.Discard); err == nil {
+				t.Error("VerifyBundle passed a damaged bundle")
+			}
+			dst, err := Init(t.TempDir(), InitOptions{Log: io.Discard})
+			if err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			if err := dst.ImportBundle(bundle); err == nil {
+				t.Error("ImportBundle accepted a damaged bundle")
+			}
+			if tags, _ := dst.Tags(); len(tags) != 0 {
+				t.Errorf("import left versions %v", tags)
+			}
+		})
+	}
+}
+
+func TestImportBundleChecksEntries(t *testing.T) {
+	src := newTestRepo(t)
+	storeVersion(t, src, "v1", zipArchive(t, [2]string{"a.txt", "a"}, [2]string{"../pwned.txt", "owned"}))
+	bundle := filepath.Join(t.TempDir(), "evil.zbundle")
+	if err := src.CreateBundle(bundle, nil); err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	dst, err := Init(t.TempDir(), InitOptions{Log: io.Discard})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := dst.ImportBundle(bundle); err == nil || !strings.Contains(err.Error(), "outside the working copy") {
+		t.Fatalf("ImportBundle = %v, want the unsafe entry rejected", err)
+	}
+	if objects, _ := dst.storage.List(); len(objects) != 0 {
+		t.Errorf("rejected archive left in storage: %v", objects)
+	}
+}
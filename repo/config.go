@@ -0,0 +1,318 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UserConfig holds the settings of the user config file, which apply to
+// every repository of that user
+type UserConfig struct {
+	Author            string `json:"author,omitempty"`
+	Email             string `json:"email,omitempty"`
+	CompressionMethod string `json:"compression_method,omitempty"` // used when the repository sets none
+	CompressionLevel  int    `json:"compression_level,omitempty"`
+}
+
+// UserConfigPath returns the user config file, config in the zippy folder
+// of the user config directory
+func UserConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "zippy", "config"), nil
+}
+
+// LoadUserConfig reads the user config file. A missing file is an empty
+// config.
+func LoadUserConfig() (UserConfig, error) {
+	var config UserConfig
+	path, err := UserConfigPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid user config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Identity returns the name and email new versions are credited to. Each
+// comes from ZIPPY_AUTHOR_NAME or ZIPPY_AUTHOR_EMAIL, then the user config,
+// then the repository config; the name defaults to Unknown.
+func (zippy *Repo) Identity() (string, string) {
+	name, email := os.Getenv("ZIPPY_AUTHOR_NAME"), os.Getenv("ZIPPY_AUTHOR_EMAIL")
+	for _, layer := range []struct{ name, email string }{
+		{zippy.user.Author, zippy.user.Email},
+		{zippy.config.Author, zippy.config.Email},
+	} {
+		if name == "" {
+			name = layer.name
+		}
+		if email == "" {
+			email = layer.email
+		}
+	}
+	if name == "" {
+		name = "Unknown"
+	}
+	return name, email
+}
+
+// ParseAuthor splits "Name <email>" into name and email. Without angle
+// brackets the whole text is the name.
+func ParseAuthor(author string) (string, string) {
+	author = strings.TrimSpace(author)
+	start, end := strings.LastIndex(author, "<"), strings.LastIndex(author, ">")
+	if start < 0 || end < start {
+		return author, ""
+	}
+	return strings.TrimSpace(author[:start]), strings.TrimSpace(author[start+1 : end])
+}
+
+// FormatAuthor joins a name and email as "Name <email>"
+func FormatAuthor(name string, email string) string {
+	if email == "" {
+		return name
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+// Where a setting comes from
+const (
+	SCOPE_REPO = "repo"
+	SCOPE_USER = "user"
+)
+
+// configKeys are the settings 'zippy config' reads and writes, by their
+// names in the config files. Name and description only exist in the
+// repository config.
+var configKeys = []string{"name", "description", "author", "email", "compression_method", "compression_level"}
+
+// Setting is a config key with its value and the file it was read from
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Scope string `json:"scope"` // repo or user
+}
+
+// ConfigKeys returns the keys that can be set with SetConfig and
+// SetUserConfig
+func ConfigKeys() []string {
+	return slices.Clone(configKeys)
+}
+
+// Settings returns every key that is set, with the repository config taking
+// precedence over the user config. For author and email it is the other way
+// round: the user config says who you are, the repository config who owns
+// the project.
+func (zippy *Repo) Settings() []Setting {
+	settings := []Setting{}
+	for _, key := range configKeys {
+		repoValue, inRepo := configValue(&zippy.config, key)
+		userValue, inUser := configValue(&zippy.user, key)
+		userFirst := key == "author" || key == "email"
+		switch {
+		case inUser && (userFirst || !inRepo):
+			settings = append(settings, Setting{Key: key, Value: userValue, Scope: SCOPE_USER})
+		case inRepo:
+			settings = append(settings, Setting{Key: key, Value: repoValue, Scope: SCOPE_REPO})
+		}
+	}
+	return settings
+}
+
+// SetConfig sets a key in .zippy/config.json
+func (zippy *Repo) SetConfig(key string, value string) error {
+	config := zippy.config
+	if err := setConfigValue(&config, key, &value); err != nil {
+		return err
+	}
+	if err := validateLayeredCompression(config, zippy.user); err != nil {
+		return err
+	}
+	zippy.config = config
+	return zippy.saveConfig()
+}
+
+// UnsetConfig removes a key from .zippy/config.json
+func (zippy *Repo) UnsetConfig(key string) error {
+	config := zippy.config
+	if err := setConfigValue(&config, key, nil); err != nil {
+		return err
+	}
+	if err := validateLayeredCompression(config, zippy.user); err != nil {
+		return err
+	}
+	zippy.config = config
+	return zippy.saveConfig()
+}
+
+// UserSettings returns the keys set in the user config
+func UserSettings() ([]Setting, error) {
+	user, err := LoadUserConfig()
+	if err != nil {
+		return nil, err
+	}
+	settings := []Setting{}
+	for _, key := range configKeys {
+		if value, ok := configValue(&user, key); ok {
+			settings = append(settings, Setting{Key: key, Value: value, Scope: SCOPE_USER})
+		}
+	}
+	return settings, nil
+}
+
+// SetUserConfig sets a key in the user config file, creating it if needed
+func SetUserConfig(key string, value string) error {
+	user, err := LoadUserConfig()
+	if err != nil {
+		return err
+	}
+	if err := setConfigValue(&user, key, &value); err != nil {
+		return err
+	}
+	if err := validateLayeredCompression(RepoConfig{}, user); err != nil {
+		return err
+	}
+	return saveUserConfig(user)
+}
+
+// UnsetUserConfig removes a key from the user config file
+func UnsetUserConfig(key string) error {
+	user, err := LoadUserConfig()
+	if err != nil {
+		return err
+	}
+	if err := setConfigValue(&user, key, nil); err != nil {
+		return err
+	}
+	if err := validateLayeredCompression(RepoConfig{}, user); err != nil {
+		return err
+	}
+	return saveUserConfig(user)
+}
+
+// saveUserConfig writes the user config file
+func saveUserConfig(user UserConfig) error {
+	path, err := UserConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// configField finds the field of a config struct by its JSON name
+func configField(config any, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if name == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// configValue returns a key of a config struct as text, if it is set
+func configValue(config any, key string) (string, bool) {
+	field, ok := configField(config, key)
+	if !ok || field.IsZero() {
+		return "", false
+	}
+	return fmt.Sprint(field.Interface()), true
+}
+
+// setConfigValue sets a key of a config struct, or clears it when value is
+// nil
+func setConfigValue(config any, key string, value *string) error {
+	if !slices.Contains(configKeys, key) {
+		return invalidError("unknown config key %q (use one of %s)", key, strings.Join(configKeys, ", "))
+	}
+	field, ok := configField(config, key)
+	if !ok {
+		return invalidError("%s can only be set in a repository config", key)
+	}
+	if value == nil {
+		field.SetZero()
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(*value)
+		if err != nil {
+			return invalidError("%s must be a number", key)
+		}
+		field.SetInt(int64(n))
+	default:
+		field.SetString(*value)
+	}
+	return nil
+}
+
+// compressionMethod is the configured method, from the repository or else
+// the user config
+func (zippy *Repo) compressionMethod() string {
+	method, _ := layeredCompression(zippy.config, zippy.user)
+	return method
+}
+
+// compressionLevel is the configured level, from the repository or else
+// the user config
+func (zippy *Repo) compressionLevel() int {
+	_, level := layeredCompression(zippy.config, zippy.user)
+	return level
+}
+
+// layeredCompression picks the method and the level each from the
+// repository config or else the user config, so the pair may come from
+// different files
+func layeredCompression(config RepoConfig, user UserConfig) (string, int) {
+	method, level := config.CompressionMethod, config.CompressionLevel
+	if method == "" {
+		method = user.CompressionMethod
+	}
+	if level == 0 {
+		level = user.CompressionLevel
+	}
+	return method, level
+}
+
+// validateLayeredCompression checks the method and level that apply once
+// both configs are layered, since each may be valid on its own while the
+// pair is not, such as a zstd level from one with deflate from the other
+func validateLayeredCompression(config RepoConfig, user UserConfig) error {
+	method, level := layeredCompression(config, user)
+	if err := validateCompression(method, level); err != nil {
+		return invalidError("%v (compression_method %q with compression_level %d, from the repository and user config together)", err, method, level)
+	}
+	return nil
+}
+
+// checkCompression fails before an archive is written with a method and
+// level that don't go together. The user config is shared by every
+// repository, so a change to it is only checked against this one here.
+func (zippy *Repo) checkCompression() error {
+	return validateLayeredCompression(zippy.config, zippy.user)
+}
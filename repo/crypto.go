@@ -0,0 +1,619 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encryptionMagic starts every encrypted object and metadata file. It is
+// followed by the format byte, the 8-byte id of the data key and a 7-byte
+// nonce prefix; the rest is a series of AES-GCM sealed chunks.
+var encryptionMagic = []byte("ZIPPYENC")
+
+// repoKeys holds the unwrapped data keys of an encrypted repository
+type repoKeys struct {
+	current  []byte
+	previous []byte // only set while a key rotation is unfinished
+}
+
+// byID returns the data key with the given id, or nil if neither matches
+func (k *repoKeys) byID(id []byte) []byte {
+	for _, key := range [][]byte{k.current, k.previous} {
+		if key != nil && bytes.Equal(keyID(key), id) {
+			return key
+		}
+	}
+	return nil
+}
+
+// keyID identifies a data key without revealing it
+func keyID(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("zippy key id\x00"), key...))
+	return sum[:8]
+}
+
+// unlock derives the key-encryption key from the passphrase or key file and
+// unwraps the data keys. The result is cached for the rest of the command,
+// and so is a failure, so a wrong passphrase is not asked for again per file.
+func (zippy *Repo) unlock() (*repoKeys, error) {
+	if zippy.keys != nil || zippy.unlockErr != nil {
+		return zippy.keys, zippy.unlockErr
+	}
+	keys, err := zippy.unwrapKeys()
+	if err != nil {
+		zippy.unlockErr = err
+		return nil, err
+	}
+	zippy.keys = keys
+	return keys, nil
+}
+
+// unwrapKeys reads the passphrase or key file and opens the wrapped keys
+func (zippy *Repo) unwrapKeys() (*repoKeys, error) {
+	cfg := zippy.config.Encryption
+	if cfg == nil {
+		return nil, fmt.Errorf("repository is not encrypted")
+	}
+	var secret []byte
+	var err error
+	if cfg.Mode == ENCRYPTION_KEYFILE {
+		secret, err = readKeyFile(zippy.keyFilePath())
+	} else {
+		secret, err = zippy.passphrase("Passphrase: ", "ZIPPY_PASSPHRASE", false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	kek, err := deriveKEK(cfg, secret)
+	if err != nil {
+		return nil, err
+	}
+	keys := &repoKeys{}
+	if keys.current, err = unwrapKey(kek, cfg.WrappedKey); err != nil {
+		return nil, fmt.Errorf("wrong passphrase or key file")
+	}
+	if cfg.PreviousWrappedKey != "" {
+		if keys.previous, err = unwrapKey(kek, cfg.PreviousWrappedKey); err != nil {
+			return nil, fmt.Errorf("corrupt previous key in config: %v", err)
+		}
+	}
+	return keys, nil
+}
+
+// keyFilePath returns the key file of the repository. ZIPPY_KEYFILE
+// overrides the path recorded at init, e.g. on another machine.
+func (zippy *Repo) keyFilePath() string {
+	if path := os.Getenv("ZIPPY_KEYFILE"); path != "" {
+		return path
+	}
+	return zippy.config.Encryption.KeyFile
+}
+
+// passphrase reads a passphrase from the environment variable envName or,
+// failing that, asks the Passphrase callback
+func (zippy *Repo) passphrase(prompt string, envName string, confirm bool) ([]byte, error) {
+	if value := os.Getenv(envName); value != "" {
+		return []byte(value), nil
+	}
+	if zippy.Passphrase == nil {
+		return nil, fmt.Errorf("%w; set %s", ErrNoPassphrase, envName)
+	}
+	passphrase, err := zippy.Passphrase(prompt, confirm)
+	if errors.Is(err, ErrNoPassphrase) {
+		return nil, fmt.Errorf("%w; set %s", err, envName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	return passphrase, nil
+}
+
+// readKeyFile reads the secret stored in a key file
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	if len(data) < 16 {
+		return nil, fmt.Errorf("key file %s is too short (need at least 16 bytes)", path)
+	}
+	return data, nil
+}
+
+// loadOrCreateKeyFile reads a key file, creating one with a random key if
+// it does not exist yet
+func (zippy *Repo) loadOrCreateKeyFile(path string) ([]byte, error) {
+	if _, err := os.Stat(path); err == nil {
+		return readKeyFile(path)
+	}
+	data := []byte(hex.EncodeToString(randomBytes(32)) + "\n")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %v", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	zippy.logf("Created key file %s - keep a copy somewhere safe\n", path)
+	return data, nil
+}
+
+// deriveKEK turns a passphrase or key file into the key that wraps the data
+// key. Passphrases go through PBKDF2; key files are already random, so HKDF
+// is enough.
+func deriveKEK(cfg *EncryptionConfig, secret []byte) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(cfg.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt salt in config: %v", err)
+	}
+	switch cfg.Mode {
+	case ENCRYPTION_KEYFILE:
+		return hkdf.Key(sha256.New, secret, salt, "zippy keyfile", 32)
+	case ENCRYPTION_PASSPHRASE:
+		return pbkdf2.Key(sha256.New, string(secret), salt, cfg.Iterations, 32)
+	}
+	return nil, fmt.Errorf("unknown encryption mode %q (use passphrase or keyfile)", cfg.Mode)
+}
+
+// newEncryptionConfig wraps dataKey with a key derived from secret using a
+// fresh salt. It also returns the key-encryption key.
+func newEncryptionConfig(mode string, keyFile string, secret []byte, dataKey []byte) (*EncryptionConfig, []byte, error) {
+	cfg := &EncryptionConfig{
+		Mode: mode,
+		Salt: base64.StdEncoding.EncodeToString(randomBytes(16)),
+	}
+	if mode == ENCRYPTION_KEYFILE {
+		cfg.KeyFile = keyFile
+	} else {
+		cfg.Iterations = ENCRYPTION_ITERATIONS
+	}
+	kek, err := deriveKEK(cfg, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.WrappedKey, err = wrapKey(kek, dataKey); err != nil {
+		return nil, nil, err
+	}
+	return cfg, kek, nil
+}
+
+// wrapKey seals a data key with the key-encryption key
+func wrapKey(kek []byte, key []byte) (string, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := randomBytes(aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, key, []byte("zippy data key"))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrapKey opens a data key sealed by wrapKey
+func unwrapKey(kek []byte, wrapped string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte("zippy data key"))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// chunkNonce builds the nonce of chunk counter from the nonce prefix in
+// header. The last chunk is marked so a truncated stream fails to open.
+func chunkNonce(header []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[ENCRYPTION_HEADER-7:])
+	binary.BigEndian.PutUint32(nonce[7:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it in fixed-size chunks, so
+// archives of any size can be streamed. Close must be called to write the
+// final chunk.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, ENCRYPTION_HEADER)
+	header = append(header, encryptionMagic...)
+	header = append(header, ENCRYPTION_FORMAT)
+	header = append(header, keyID(key)...)
+	header = append(header, randomBytes(7)...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, ENCRYPTION_CHUNK)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, because
+		// the last chunk has to be marked as such
+		if len(e.buf) == ENCRYPTION_CHUNK {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		take := min(len(p), ENCRYPTION_CHUNK-len(e.buf))
+		e.buf = append(e.buf, p[:take]...)
+		p = p[take:]
+		n += take
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.header, e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader reads a stream written by encryptWriter, checking every
+// chunk before returning any of it
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+func newDecryptReader(r io.Reader, keys *repoKeys) (*decryptReader, error) {
+	header := make([]byte, ENCRYPTION_HEADER)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, encryptionMagic) {
+		return nil, fmt.Errorf("not an encrypted file")
+	}
+	if header[len(encryptionMagic)] != ENCRYPTION_FORMAT {
+		return nil, fmt.Errorf("unsupported encryption format %d", header[len(encryptionMagic)])
+	}
+	key := keys.byID(header[len(encryptionMagic)+1 : ENCRYPTION_HEADER-7])
+	if key == nil {
+		return nil, fmt.Errorf("encrypted with a key this repository does not have")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	size := ENCRYPTION_CHUNK + aead.Overhead()
+	return &decryptReader{
+		r:      bufio.NewReaderSize(r, size),
+		aead:   aead,
+		header: header,
+		chunk:  make([]byte, size),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next reads and opens the following chunk. A short chunk, or a full one
+// with nothing after it, has to be the last.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.header, d.counter, last), d.chunk[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("encrypted data is corrupt or truncated")
+	}
+	d.counter++
+	d.buf = plain
+	d.done = last
+	return nil
+}
+
+// encryptBytes encrypts a small payload such as version metadata
+func (zippy *Repo) encryptBytes(data []byte) ([]byte, error) {
+	keys, err := zippy.unlock()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := newEncryptWriter(&buf, keys.current)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptBytes reverses encryptBytes
+func (zippy *Repo) decryptBytes(data []byte) ([]byte, error) {
+	keys, err := zippy.unlock()
+	if err != nil {
+		return nil, err
+	}
+	r, err := newDecryptReader(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// encryptedStorage encrypts objects on the way into another backend and
+// decrypts them on the way out. List and Stat report the encrypted sizes.
+type encryptedStorage struct {
+	Storage
+	zippy *Repo
+}
+
+func (s *encryptedStorage) Put(name string, r io.Reader) error {
+	keys, err := s.zippy.unlock()
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w, err := newEncryptWriter(pw, keys.current)
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	err = s.Storage.Put(name, pr)
+	// Unblock the encrypting goroutine if the backend gave up early
+	pr.Close()
+	<-done
+	return err
+}
+
+func (s *encryptedStorage) Get(name string) (io.ReadCloser, error) {
+	keys, err := s.zippy.unlock()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.Storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := newDecryptReader(rc, keys)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	// Deliberately not an io.ReaderAt, so callers stream the plaintext
+	return struct {
+		io.Reader
+		io.Closer
+	}{dr, rc}, nil
+}
+
+// storedKeyID returns the id of the data key an object was encrypted with
+func (s *encryptedStorage) storedKeyID(name string) ([]byte, error) {
+	rc, err := s.Storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	header := make([]byte, ENCRYPTION_HEADER)
+	if _, err := io.ReadFull(rc, header); err != nil || !bytes.HasPrefix(header, encryptionMagic) {
+		return nil, fmt.Errorf("%s is not encrypted", name)
+	}
+	return header[len(encryptionMagic)+1 : ENCRYPTION_HEADER-7], nil
+}
+
+// RotateKey re-encrypts every archive and metadata file under a new data
+// key, optionally switching between passphrase and key file. The old key
+// stays in config.json until everything is rewritten, so an interrupted
+// rotation is finished by running the command again.
+func (zippy *Repo) RotateKey(mode string, keyFile string) error {
+	storage, ok := zippy.storage.(*encryptedStorage)
+	if !ok {
+		return errors.New("repository is not encrypted. Encryption is chosen at 'zippy init'")
+	}
+	keys, err := zippy.unlock()
+	if err != nil {
+		return fmt.Errorf("failed to unlock repository: %w", err)
+	}
+
+	if zippy.config.Encryption.PreviousWrappedKey != "" {
+		zippy.logf("Resuming unfinished key rotation...\n")
+	} else {
+		if mode == "" {
+			mode = zippy.config.Encryption.Mode
+			if mode == ENCRYPTION_KEYFILE {
+				keyFile = zippy.keyFilePath()
+			}
+		}
+		var secret []byte
+		if mode == ENCRYPTION_KEYFILE {
+			if keyFile, err = filepath.Abs(keyFile); err == nil {
+				secret, err = zippy.loadOrCreateKeyFile(keyFile)
+			}
+		} else {
+			secret, err = zippy.passphrase("New passphrase: ", "ZIPPY_NEW_PASSPHRASE", true)
+		}
+		if err != nil {
+			return err
+		}
+		dataKey := randomBytes(32)
+		cfg, kek, err := newEncryptionConfig(mode, keyFile, secret, dataKey)
+		if err == nil {
+			cfg.PreviousWrappedKey, err = wrapKey(kek, keys.current)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create new key: %w", err)
+		}
+		zippy.config.Encryption = cfg
+		if err := zippy.saveConfig(); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+		keys = &repoKeys{current: dataKey, previous: keys.current}
+		zippy.keys = keys
+	}
+	current := keyID(keys.current)
+
+	objects, err := storage.List()
+	if err != nil {
+		return fmt.Errorf("failed to list storage: %w", err)
+	}
+	rewritten := 0
+	for _, obj := range objects {
+		id, err := storage.storedKeyID(obj.Name)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(id, current) {
+			continue
+		}
+		if err := zippy.reencryptObject(obj.Name); err != nil {
+			return fmt.Errorf("failed to re-encrypt %s: %w", obj.Name, err)
+		}
+		zippy.logf("  Re-encrypted: %s\n", obj.Name)
+		rewritten++
+	}
+
+	files, err := os.ReadDir(zippy.versionsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read versions: %w", err)
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(zippy.versionsPath, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name(), err)
+		}
+		if len(data) >= ENCRYPTION_HEADER && bytes.Equal(data[len(encryptionMagic)+1:ENCRYPTION_HEADER-7], current) {
+			continue
+		}
+		v, err := zippy.loadVersion(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		if err := zippy.saveVersionInfo(v); err != nil {
+			return err
+		}
+		rewritten++
+	}
+
+	zippy.config.Encryption.PreviousWrappedKey = ""
+	if err := zippy.saveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	keys.previous = nil
+	zippy.logf("Re-encrypted %d files. Key rotation complete.\n", rewritten)
+	return nil
+}
+
+// reencryptObject decrypts a storage object to a temp file and stores it
+// again under the current key
+func (zippy *Repo) reencryptObject(name string) error {
+	in, err := zippy.storage.Get(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "zippy_rotate_*")
+	if err != nil {
+		in.Close()
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	_, err = io.Copy(tmp, in)
+	in.Close()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return zippy.storage.Put(name, tmp)
+}
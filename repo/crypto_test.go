@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key, other := randomBytes(32), randomBytes(32)
+	sizes := []int{0, 1, ENCRYPTION_CHUNK - 1, ENCRYPTION_CHUNK, ENCRYPTION_CHUNK + 1, 3 * ENCRYPTION_CHUNK}
+	for _, size := range sizes {
+		plain := randomBytes(size)
+		var sealed bytes.Buffer
+		w, err := newEncryptWriter(&sealed, key)
+		if err != nil {
+			t.Fatalf("newEncryptWriter: %v", err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatalf("%d bytes: Close: %v", size, err)
+		}
+		r, err := newDecryptReader(bytes.NewReader(sealed.Bytes()), &repoKeys{current: other, previous: key})
+		if err != nil {
+			t.Fatalf("%d bytes: newDecryptReader: %v", size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: decrypted %d bytes, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestDecryptRejectsDamage(t *testing.T) {
+	key := randomBytes(32)
+	var sealed bytes.Buffer
+	w, _ := newEncryptWriter(&sealed, key)
+	w.Write(randomBytes(2*ENCRYPTION_CHUNK + 100))
+	w.Close()
+	data := sealed.Bytes()
+	chunk := ENCRYPTION_CHUNK + 16
+
+	tests := []struct {
+		name   string
+		damage func([]byte) []byte
+		keys   *repoKeys
+	}{
+		{"wrong key", func(b []byte) []byte { return b }, &repoKeys{current: randomBytes(32)}},
+		{"flipped byte", func(b []byte) []byte { b[ENCRYPTION_HEADER+10] ^= 1; return b }, nil},
+		{"flipped header", func(b []byte) []byte { b[ENCRYPTION_HEADER-1] ^= 1; return b }, nil},
+		{"last chunk dropped", func(b []byte) []byte { return b[:ENCRYPTION_HEADER+2*chunk] }, nil},
+		{"truncated chunk", func(b []byte) []byte { return b[:len(b)-1] }, nil},
+		{"chunks swapped", func(b []byte) []byte {
+			first := bytes.Clone(b[ENCRYPTION_HEADER : ENCRYPTION_HEADER+chunk])
+			copy(b[ENCRYPTION_HEADER:], b[ENCRYPTION_HEADER+chunk:ENCRYPTION_HEADER+2*chunk])
+			copy(b[ENCRYPTION_HEADER+chunk:], first)
+			return b
+		}, nil},
+		{"not encrypted", func(b []byte) []byte { return []byte("PK\x03\x04 plain zip") }, nil},
+	}
+	for _, tt := range tests {
+		keys := tt.keys
+		if keys == nil {
+			keys = &repoKeys{current: key}
+		}
+		r, err := newDecryptReader(bytes.NewReader(tt.damage(bytes.Clone(data))), keys)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if err == nil {
+			t.Errorf("%s: decrypted without an error", tt.name)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string // empty keeps the key file
+		newFile string
+	}{
+		{"same key file", "", ""},
+		{"new key file", ENCRYPTION_KEYFILE, "new.key"},
+		{"to passphrase", ENCRYPTION_PASSPHRASE, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("ZIPPY_KEYFILE", "")
+			t.Setenv("ZIPPY_PASSPHRASE", "rotated secret")
+			t.Setenv("ZIPPY_NEW_PASSPHRASE", "rotated secret")
+			keys := t.TempDir()
+			zippy, err := Init(t.TempDir(), InitOptions{Log: io.Discard, Encryption: ENCRYPTION_KEYFILE, KeyFile: filepath.Join(keys, "old.key")})
+			if err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			storeVersion(t, zippy, "v1", zipArchive(t, [2]string{"a.txt", "first"}))
+			storeVersion(t, zippy, "v2", zipArchive(t, [2]string{"a.txt", "second"}))
+			old := zippy.keys.current
+
+			newFile := ""
+			if tt.newFile != "" {
+				newFile = filepath.Join(keys, tt.newFile)
+			}
+			if err := zippy.RotateKey(tt.mode, newFile); err != nil {
+				t.Fatalf("RotateKey: %v", err)
+			}
+			if bytes.Equal(zippy.keys.current, old) || zippy.config.Encryption.PreviousWrappedKey != "" {
+				t.Fatal("rotation left the old key in place")
+			}
+			storage := zippy.storage.(*encryptedStorage)
+			objects, _ := storage.List()
+			for _, obj := range objects {
+				if id, err := storage.storedKeyID(obj.Name); err != nil || !bytes.Equal(id, keyID(zippy.keys.current)) {
+					t.Errorf("%s is not under the new key: %v", obj.Name, err)
+				}
+			}
+
+			// A fresh open has to unlock with the new secret alone
+			reopened, err := Open(zippy.repoPath)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			reopened.Log = io.Discard
+			for tag, want := range map[string]string{"v1": "first", "v2": "second"} {
+				rc, err := reopened.OpenFile(tag, "a.txt")
+				if err != nil {
+					t.Fatalf("OpenFile %s: %v", tag, err)
+				}
+				got, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil || string(got) != want {
+					t.Errorf("%s a.txt = %q, %v, want %q", tag, got, err, want)
+				}
+			}
+		})
+	}
+}
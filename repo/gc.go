@@ -0,0 +1,243 @@
+package repo
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// GC removes storage files that no version references, consolidates small
+// loose archives into a pack file and, when aggressive, recompresses every
+// archive at the best compression level
+func (zippy *Repo) GC(aggressive bool, dryRun bool) error {
+	zippy.logf("Collecting garbage in storage...\n")
+	started := time.Now()
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return fmt.Errorf("%w. Refusing to collect garbage while version metadata is unreadable", err)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Timestamp.Before(versions[j].Timestamp)
+	})
+	before, err := zippy.storageSize()
+	if err != nil {
+		return fmt.Errorf("failed to list storage: %w", err)
+	}
+
+	problems := 0
+	written := map[string]bool{} // archives gc itself wrote after it started
+	if !dryRun {
+		if aggressive {
+			for i := range versions {
+				rewritten, err := zippy.recompressVersion(&versions[i])
+				if err != nil {
+					zippy.logf("  [Error recompressing %s]: %v\n", versions[i].Tag, err)
+					problems++
+				} else if rewritten {
+					written[zippy.archiveName(versions[i])] = true
+					zippy.logf("  Recompressed: %s\n", versions[i].Tag)
+				}
+			}
+		}
+		if err := zippy.repackVersions(versions); err != nil {
+			zippy.logf("  [Error packing versions]: %v\n", err)
+			problems++
+		}
+	}
+
+	// Anything left in storage that no version points at is an orphan
+	referenced := map[string]bool{}
+	for _, v := range versions {
+		name := zippy.archiveName(v)
+		referenced[name] = true
+		if _, err := zippy.storage.Stat(name); err != nil {
+			zippy.logf("  [Missing archive for %s]\n", v.Tag)
+			problems++
+		}
+	}
+	objects, err := zippy.storage.List()
+	if err != nil {
+		return fmt.Errorf("failed to list storage: %w", err)
+	}
+	orphans := 0
+	var orphanBytes int64
+	for _, obj := range objects {
+		// Only archives and their temp files are Zippy's to remove; other
+		// objects may share the bucket
+		if referenced[obj.Name] || (!isArchiveObject(obj.Name) && !isTempObject(obj.Name)) {
+			continue
+		}
+		// A commit or push running alongside may have written an archive, or
+		// be writing one, that its version metadata doesn't point at yet
+		if obj.ModTime.After(started) && !written[obj.Name] {
+			continue
+		}
+		orphans++
+		orphanBytes += obj.Size
+		if dryRun {
+			zippy.logf("  Would remove: %s (%s)\n", obj.Name, FormatSize(obj.Size))
+			continue
+		}
+		if err := zippy.storage.Delete(obj.Name); err != nil {
+			zippy.logf("  [Error removing %s]\n", obj.Name)
+			problems++
+			continue
+		}
+		zippy.logf("  Removed: %s (%s)\n", obj.Name, FormatSize(obj.Size))
+	}
+
+	if dryRun {
+		zippy.logf("%d unreferenced files, %s would be reclaimed.\n", orphans, FormatSize(orphanBytes))
+	} else {
+		after, _ := zippy.storageSize()
+		zippy.logf("Removed %d unreferenced files. Reclaimed %s (%s -> %s).\n",
+			orphans, FormatSize(before-after), FormatSize(before), FormatSize(after))
+	}
+	if problems > 0 {
+		return fmt.Errorf("garbage collection finished with %d problems", problems)
+	}
+	return nil
+}
+
+// storageSize returns the total size of all stored objects
+func (zippy *Repo) storageSize() (int64, error) {
+	objects, err := zippy.storage.List()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, obj := range objects {
+		total += obj.Size
+	}
+	return total, nil
+}
+
+// recompressVersion rewrites the archive of a version at the best
+// compression level. Packed versions come out as loose archives so they can
+// be repacked. The original is kept when recompressing does not shrink it,
+// and the result reports whether the archive was rewritten.
+func (zippy *Repo) recompressVersion(v *Version) (bool, error) {
+	zr, err := zippy.openVersionZip(*v)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp("", "zippy_gc_*.zip")
+	if err != nil {
+		zr.Close()
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	err = zippy.recompressZip(zr.Reader, tmp)
+	zr.Close()
+	tmp.Close()
+	if err != nil {
+		return false, err
+	}
+	stat, err := os.Stat(tmp.Name())
+	if err != nil || stat.Size() >= v.Size {
+		return false, err
+	}
+	name := v.Tag + ".zip"
+	if err := zippy.putFile(name, tmp.Name()); err != nil {
+		return false, err
+	}
+	v.Size = stat.Size()
+	v.ZipPath = name
+	v.PackPath = ""
+	v.PackOffset = 0
+	return true, zippy.saveVersionInfo(*v)
+}
+
+// recompressZip copies every entry of zr into a new archive written to w.
+// Entries keep their compression method but use its best level.
+func (zippy *Repo) recompressZip(zr *zip.Reader, w io.Writer) error {
+	writer := zippy.newZipWriter(w, 22)
+	for _, f := range zr.File {
+		header := &zip.FileHeader{Name: f.Name, Method: f.Method, Modified: f.Modified}
+		header.SetMode(f.Mode())
+		if f.FileInfo().IsDir() {
+			header.Method = zip.Store
+			if _, err := writer.CreateHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+		out, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// repackVersions concatenates the small loose archives into a single pack
+// file and points their metadata at it. The loose copies are left for the
+// orphan sweep to remove.
+func (zippy *Repo) repackVersions(versions []Version) error {
+	candidates := []int{}
+	for i, v := range versions {
+		if v.PackPath != "" || v.Size >= ZIPPY_PACK_THRESHOLD {
+			continue
+		}
+		if _, err := zippy.storage.Stat(zippy.archiveName(v)); err != nil {
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+	if len(candidates) < 2 {
+		return nil
+	}
+	tmp, err := os.CreateTemp("", "zippy_pack_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	offsets := make([]int64, len(candidates))
+	sizes := make([]int64, len(candidates))
+	var offset int64
+	for n, i := range candidates {
+		in, err := zippy.storage.Get(zippy.archiveName(versions[i]))
+		if err != nil {
+			return err
+		}
+		written, err := io.Copy(tmp, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		offsets[n] = offset
+		sizes[n] = written
+		offset += written
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s/pack-%d.pack", ZIPPY_PACKS, time.Now().UnixNano())
+	if err := zippy.storage.Put(name, tmp); err != nil {
+		return err
+	}
+	for n, i := range candidates {
+		versions[i].ZipPath = ""
+		versions[i].PackPath = name
+		versions[i].PackOffset = offsets[n]
+		versions[i].Size = sizes[n]
+		if err := zippy.saveVersionInfo(versions[i]); err != nil {
+			return err
+		}
+	}
+	zippy.logf("  Packed %d small versions into %s\n", len(candidates), name)
+	return nil
+}
@@ -0,0 +1,926 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Git object types as stored in pack files
+const (
+	GIT_COMMIT    = 1
+	GIT_TREE      = 2
+	GIT_BLOB      = 3
+	GIT_TAG       = 4
+	GIT_OFS_DELTA = 6
+	GIT_REF_DELTA = 7
+)
+
+// gitTypes maps the type names of loose objects to pack types
+var gitTypes = map[string]int{"commit": GIT_COMMIT, "tree": GIT_TREE, "blob": GIT_BLOB, "tag": GIT_TAG}
+
+// gitRepo reads objects and refs straight from a .git directory. It
+// understands loose objects and version 2 pack indexes, which is what any
+// git since 1.5 writes.
+type gitRepo struct {
+	dir   string // the .git directory, or the repository itself when bare
+	packs []*gitPack
+	cache map[string]gitObject // recently used delta bases
+}
+
+// gitPack is an open pack file and its index
+type gitPack struct {
+	file    *os.File
+	offsets map[string]int64 // hex object id -> offset in the pack
+}
+
+type gitObject struct {
+	kind int
+	data []byte
+}
+
+// gitCommit is the part of a commit zippy needs
+type gitCommit struct {
+	id      string
+	tree    string
+	parents []string
+	author  string
+	when    time.Time
+	message string
+}
+
+// gitTreeEntry is one line of a tree object
+type gitTreeEntry struct {
+	mode string
+	name string
+	id   string
+}
+
+// openGitRepo opens the repository at path: a working copy, a bare
+// repository or a .git directory
+func openGitRepo(path string) (*gitRepo, error) {
+	dir := filepath.Join(path, ".git")
+	if data, err := os.ReadFile(dir); err == nil {
+		// Worktrees and submodules have a .git file pointing elsewhere
+		target := strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(path, target)
+		}
+		dir = target
+	} else if _, err := os.Stat(dir); err != nil {
+		dir = path
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects")); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository", path)
+	}
+	gr := &gitRepo{dir: dir, cache: map[string]gitObject{}}
+	indexes, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.idx"))
+	for _, index := range indexes {
+		pack, err := openGitPack(index)
+		if err != nil {
+			gr.close()
+			return nil, err
+		}
+		gr.packs = append(gr.packs, pack)
+	}
+	return gr, nil
+}
+
+func (gr *gitRepo) close() {
+	for _, pack := range gr.packs {
+		pack.file.Close()
+	}
+}
+
+// openGitPack reads a version 2 pack index and opens its pack
+func openGitPack(indexPath string) (*gitPack, error) {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, fmt.Errorf("%s: unsupported pack index version", indexPath)
+	}
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	ids := 8 + 256*4
+	offsets32 := ids + count*20 + count*4
+	offsets64 := offsets32 + count*4
+	if len(data) < offsets64 {
+		return nil, fmt.Errorf("%s: truncated pack index", indexPath)
+	}
+	pack := &gitPack{offsets: make(map[string]int64, count)}
+	for i := 0; i < count; i++ {
+		id := hex.EncodeToString(data[ids+i*20 : ids+i*20+20])
+		offset := int64(binary.BigEndian.Uint32(data[offsets32+i*4:]))
+		if offset&0x80000000 != 0 {
+			// Packs over 2 GiB keep large offsets in a second table
+			at := offsets64 + int(offset&0x7fffffff)*8
+			if len(data) < at+8 {
+				return nil, fmt.Errorf("%s: truncated pack index", indexPath)
+			}
+			offset = int64(binary.BigEndian.Uint64(data[at:]))
+		}
+		pack.offsets[id] = offset
+	}
+	if pack.file, err = os.Open(strings.TrimSuffix(indexPath, ".idx") + ".pack"); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// object reads an object by its hex id from a pack or the loose objects
+func (gr *gitRepo) object(id string) (gitObject, error) {
+	if obj, ok := gr.cache[id]; ok {
+		return obj, nil
+	}
+	for _, pack := range gr.packs {
+		if offset, ok := pack.offsets[id]; ok {
+			return gr.packObject(pack, offset)
+		}
+	}
+	if len(id) != 40 {
+		return gitObject{}, fmt.Errorf("invalid object id %q", id)
+	}
+	file, err := os.Open(filepath.Join(gr.dir, "objects", id[:2], id[2:]))
+	if err != nil {
+		return gitObject{}, fmt.Errorf("object %s not found", id)
+	}
+	defer file.Close()
+	zr, err := zlib.NewReader(file)
+	if err != nil {
+		return gitObject{}, fmt.Errorf("object %s: %v", id, err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return gitObject{}, fmt.Errorf("object %s: %v", id, err)
+	}
+	header, data, ok := bytes.Cut(raw, []byte{0})
+	kind, _, _ := strings.Cut(string(header), " ")
+	if !ok || gitTypes[kind] == 0 {
+		return gitObject{}, fmt.Errorf("object %s: corrupt header", id)
+	}
+	return gitObject{kind: gitTypes[kind], data: data}, nil
+}
+
+// packObject reads the object at offset in a pack, resolving deltas
+func (gr *gitRepo) packObject(pack *gitPack, offset int64) (gitObject, error) {
+	key := fmt.Sprintf("%p@%d", pack, offset)
+	if obj, ok := gr.cache[key]; ok {
+		return obj, nil
+	}
+	r := bufio.NewReader(io.NewSectionReader(pack.file, offset, 1<<62))
+	c, err := r.ReadByte()
+	if err != nil {
+		return gitObject{}, err
+	}
+	kind := int(c>>4) & 7
+	for c&0x80 != 0 {
+		// The rest of the size varint; the inflated data tells the size
+		if c, err = r.ReadByte(); err != nil {
+			return gitObject{}, err
+		}
+	}
+
+	var base gitObject
+	switch kind {
+	case GIT_OFS_DELTA:
+		c, err := r.ReadByte()
+		if err != nil {
+			return gitObject{}, err
+		}
+		distance := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return gitObject{}, err
+			}
+			distance = (distance+1)<<7 | int64(c&0x7f)
+		}
+		if base, err = gr.packObject(pack, offset-distance); err != nil {
+			return gitObject{}, err
+		}
+	case GIT_REF_DELTA:
+		id := make([]byte, 20)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return gitObject{}, err
+		}
+		if base, err = gr.object(hex.EncodeToString(id)); err != nil {
+			return gitObject{}, err
+		}
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return gitObject{}, err
+	}
+	data, err := io.ReadAll(zr)
+	zr.Close()
+	if err != nil {
+		return gitObject{}, err
+	}
+	obj := gitObject{kind: kind, data: data}
+	if kind == GIT_OFS_DELTA || kind == GIT_REF_DELTA {
+		if data, err = applyGitDelta(base.data, data); err != nil {
+			return gitObject{}, err
+		}
+		obj = gitObject{kind: base.kind, data: data}
+	}
+	// Keep the most recent objects around, since deltas in a chain often
+	// share their bases
+	if len(gr.cache) >= 256 {
+		gr.cache = map[string]gitObject{}
+	}
+	gr.cache[key] = obj
+	return obj, nil
+}
+
+// applyGitDelta rebuilds an object from its base and a delta
+func applyGitDelta(base []byte, delta []byte) ([]byte, error) {
+	errCorrupt := fmt.Errorf("corrupt delta")
+	varint := func() (int, bool) {
+		n, shift := 0, 0
+		for len(delta) > 0 {
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				return n, true
+			}
+		}
+		return 0, false
+	}
+	baseSize, ok1 := varint()
+	size, ok2 := varint()
+	if !ok1 || !ok2 || baseSize != len(base) {
+		return nil, errCorrupt
+	}
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			// Copy from base: the low bits say which offset and size
+			// bytes follow
+			var offset, n int
+			for i := uint(0); i < 7; i++ {
+				if cmd&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errCorrupt
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					n |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > len(base) {
+				return nil, errCorrupt
+			}
+			out = append(out, base[offset:offset+n]...)
+		case cmd != 0:
+			// Insert the next cmd bytes
+			if int(cmd) > len(delta) {
+				return nil, errCorrupt
+			}
+			out = append(out, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, errCorrupt
+		}
+	}
+	if len(out) != size {
+		return nil, errCorrupt
+	}
+	return out, nil
+}
+
+// refs returns the refs under prefix (e.g. "refs/tags/"), loose and packed,
+// as name -> hex object id
+func (gr *gitRepo) refs(prefix string) map[string]string {
+	refs := map[string]string{}
+	if data, err := os.ReadFile(filepath.Join(gr.dir, "packed-refs")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			id, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+			if ok && len(id) == 40 && strings.HasPrefix(name, prefix) {
+				refs[name] = id
+			}
+		}
+	}
+	root := filepath.Join(gr.dir, filepath.FromSlash(prefix))
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(gr.dir, path)
+		if data, err := os.ReadFile(path); err == nil {
+			refs[filepath.ToSlash(rel)] = strings.TrimSpace(string(data))
+		}
+		return nil
+	})
+	return refs
+}
+
+// resolve turns a ref name such as "HEAD", "main" or "refs/tags/v1" into
+// the id of the commit it points at
+func (gr *gitRepo) resolve(name string) (string, error) {
+	candidates := []string{name, "refs/heads/" + name, "refs/tags/" + name}
+	for depth := 0; depth < 10; depth++ {
+		value := ""
+		for _, ref := range candidates {
+			if value = gr.readRef(ref); value != "" {
+				break
+			}
+		}
+		if value == "" {
+			return "", fmt.Errorf("unknown ref %s", name)
+		}
+		target, symbolic := strings.CutPrefix(value, "ref: ")
+		if !symbolic {
+			return gr.peel(value)
+		}
+		candidates = []string{target}
+	}
+	return "", fmt.Errorf("ref %s is a symbolic ref loop", name)
+}
+
+// readRef returns the raw value of a loose or packed ref
+func (gr *gitRepo) readRef(ref string) string {
+	if data, err := os.ReadFile(filepath.Join(gr.dir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return gr.refs(ref)[ref]
+}
+
+// peel follows annotated tags to the commit they point at
+func (gr *gitRepo) peel(id string) (string, error) {
+	for {
+		obj, err := gr.object(id)
+		if err != nil {
+			return "", err
+		}
+		switch obj.kind {
+		case GIT_COMMIT:
+			return id, nil
+		case GIT_TAG:
+			target, ok := strings.CutPrefix(string(obj.data), "object ")
+			if !ok || len(target) < 40 {
+				return "", fmt.Errorf("tag %s: corrupt", id)
+			}
+			id = target[:40]
+		default:
+			return "", fmt.Errorf("%s is not a commit", id)
+		}
+	}
+}
+
+// commit reads and parses a commit object
+func (gr *gitRepo) commit(id string) (*gitCommit, error) {
+	obj, err := gr.object(id)
+	if err != nil {
+		return nil, err
+	}
+	if obj.kind != GIT_COMMIT {
+		return nil, fmt.Errorf("%s is not a commit", id)
+	}
+	header, message, _ := strings.Cut(string(obj.data), "\n\n")
+	c := &gitCommit{id: id, message: strings.TrimSpace(message)}
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			c.tree = value
+		case "parent":
+			c.parents = append(c.parents, value)
+		case "author":
+			c.author, c.when = parseGitSignature(value)
+		}
+	}
+	return c, nil
+}
+
+// parseGitSignature splits "Name <email> 1700000000 +0100" into the
+// identity and its time
+func parseGitSignature(value string) (string, time.Time) {
+	end := strings.LastIndex(value, ">")
+	if end < 0 {
+		return value, time.Time{}
+	}
+	identity := value[:end+1]
+	fields := strings.Fields(value[end+1:])
+	if len(fields) < 1 {
+		return identity, time.Time{}
+	}
+	seconds, _ := strconv.ParseInt(fields[0], 10, 64)
+	when := time.Unix(seconds, 0)
+	if len(fields) > 1 && len(fields[1]) == 5 {
+		hours, _ := strconv.Atoi(fields[1][1:3])
+		minutes, _ := strconv.Atoi(fields[1][3:])
+		offset := hours*3600 + minutes*60
+		if fields[1][0] == '-' {
+			offset = -offset
+		}
+		when = when.In(time.FixedZone(fields[1], offset))
+	}
+	return identity, when
+}
+
+// tree reads and parses a tree object
+func (gr *gitRepo) tree(id string) ([]gitTreeEntry, error) {
+	obj, err := gr.object(id)
+	if err != nil {
+		return nil, err
+	}
+	if obj.kind != GIT_TREE {
+		return nil, fmt.Errorf("%s is not a tree", id)
+	}
+	entries := []gitTreeEntry{}
+	data := obj.data
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		mode, name, ok2 := strings.Cut(string(header), " ")
+		if !ok || !ok2 || len(rest) < 20 {
+			return nil, fmt.Errorf("tree %s: corrupt", id)
+		}
+		entries = append(entries, gitTreeEntry{mode: mode, name: name, id: hex.EncodeToString(rest[:20])})
+		data = rest[20:]
+	}
+	return entries, nil
+}
+
+// ancestors returns every commit reachable from tip, parents before their
+// children
+func (gr *gitRepo) ancestors(tip string) ([]*gitCommit, error) {
+	commits := []*gitCommit{}
+	seen := map[string]bool{}
+	// Iterative depth-first walk that emits a commit once all of its
+	// parents have been emitted
+	type frame struct {
+		commit *gitCommit
+		next   int
+	}
+	stack := []*frame{}
+	push := func(id string) error {
+		seen[id] = true
+		c, err := gr.commit(id)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, &frame{commit: c})
+		return nil
+	}
+	if err := push(tip); err != nil {
+		return nil, err
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next < len(top.commit.parents) {
+			parent := top.commit.parents[top.next]
+			top.next++
+			if !seen[parent] {
+				if err := push(parent); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		commits = append(commits, top.commit)
+		stack = stack[:len(stack)-1]
+	}
+	return commits, nil
+}
+
+// ImportGit turns git history into versions: one per tag, or with a branch
+// one per commit reachable from it. Versions that already exist are
+// skipped, so importing again picks up only what is new.
+func (zippy *Repo) ImportGit(path string, branch string, tags bool) error {
+	if err := zippy.checkCompression(); err != nil {
+		return err
+	}
+	gr, err := openGitRepo(path)
+	if err != nil {
+		return err
+	}
+	defer gr.close()
+
+	type pending struct {
+		tag    string
+		commit *gitCommit
+	}
+	todo := []pending{}
+	tagRefs := gr.refs("refs/tags/")
+	if branch == "" && !tags {
+		// Tags are the releases, so prefer them; fall back to the
+		// history of the checked-out branch
+		tags = len(tagRefs) > 0
+		if !tags {
+			branch = "HEAD"
+		}
+	}
+	if tags {
+		zippy.logf("Importing %d tags from %s...\n", len(tagRefs), path)
+		for ref := range tagRefs {
+			id, err := gr.resolve(ref)
+			if err != nil {
+				zippy.logf("  [Skipped %s: %v]\n", ref, err)
+				continue
+			}
+			c, err := gr.commit(id)
+			if err != nil {
+				return err
+			}
+			tag := strings.ReplaceAll(strings.TrimPrefix(ref, "refs/tags/"), "/", "-")
+			todo = append(todo, pending{tag: tag, commit: c})
+		}
+		sort.Slice(todo, func(i, j int) bool {
+			if !todo[i].commit.when.Equal(todo[j].commit.when) {
+				return todo[i].commit.when.Before(todo[j].commit.when)
+			}
+			return todo[i].tag < todo[j].tag
+		})
+	} else {
+		tip, err := gr.resolve(branch)
+		if err != nil {
+			return err
+		}
+		commits, err := gr.ancestors(tip)
+		if err != nil {
+			return err
+		}
+		zippy.logf("Importing %d commits of %s from %s...\n", len(commits), branch, path)
+		for _, c := range commits {
+			todo = append(todo, pending{tag: c.id[:7], commit: c})
+		}
+	}
+
+	zippyignore := zippy.loadZippyIgnore()
+	local := &localRemote{zippy: zippy}
+	imported := 0
+	for _, p := range todo {
+		if err := validTag(p.tag); err != nil {
+			zippy.logf("  [Skipped %s: %v]\n", p.tag, err)
+			continue
+		}
+		if _, err := zippy.loadVersion(p.tag); err == nil {
+			zippy.logf("  [Exists: %s]\n", p.tag)
+			continue
+		}
+		tmp, err := os.CreateTemp("", "zippy_git_*.zip")
+		if err != nil {
+			return err
+		}
+		count, err := zippy.writeGitTree(gr, p.commit, zippyignore, tmp)
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			author, email := ParseAuthor(p.commit.author)
+			v := Version{
+				Tag:         p.tag,
+				Message:     p.commit.message,
+				Timestamp:   p.commit.when,
+				Author:      author,
+				AuthorEmail: email,
+				FilesCount:  count,
+			}
+			err = local.Store(v, tmp)
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", p.tag, err)
+		}
+		zippy.logf("  Imported: %s (%s, %d files)\n", p.tag, p.commit.id[:7], count)
+		imported++
+	}
+	zippy.logf("Import complete. %d versions created.\n", imported)
+	return nil
+}
+
+// writeGitTree writes the files of a commit to a zip archive, leaving out
+// what .zippyignore excludes, symlinks and submodules
+func (zippy *Repo) writeGitTree(gr *gitRepo, c *gitCommit, zippyignore *ZippyIgnore, w io.Writer) (int, error) {
+	writer := zippy.newZipWriter(w, zippy.compressionLevel())
+	count := 0
+	var walk func(tree string, prefix string) error
+	walk = func(tree string, prefix string) error {
+		entries, err := gr.tree(tree)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			rel := prefix + e.name
+			switch e.mode {
+			case "40000":
+				if zippyignore.shouldIgnore(rel + "/") {
+					continue
+				}
+				if err := walk(e.id, rel+"/"); err != nil {
+					return err
+				}
+			case "100644", "100755", "100664":
+				if zippyignore.shouldIgnore(rel) {
+					continue
+				}
+				obj, err := gr.object(e.id)
+				if err != nil {
+					return err
+				}
+				header := entryHeader(rel, nil, zippy.compressionMethod())
+				header.Modified = c.when
+				header.SetMode(0644)
+				if e.mode == "100755" {
+					header.SetMode(0755)
+				}
+				out, err := writer.CreateHeader(header)
+				if err != nil {
+					return err
+				}
+				if _, err := out.Write(obj.data); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+		return nil
+	}
+	if err := walk(c.tree, ""); err != nil {
+		return 0, err
+	}
+	return count, writer.Close()
+}
+
+// gitWriter writes loose objects and refs into a new .git directory
+type gitWriter struct {
+	dir string
+}
+
+// newGitWriter lays out an empty repository in dir/.git
+func newGitWriter(dir string) (*gitWriter, error) {
+	gitDir := filepath.Join(dir, ".git")
+	for _, sub := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, filepath.FromSlash(sub)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	config := "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n"
+	if err := os.WriteFile(filepath.Join(gitDir, "config"), []byte(config), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		return nil, err
+	}
+	return &gitWriter{dir: gitDir}, nil
+}
+
+// object stores an object unless it already exists and returns its id
+func (g *gitWriter) object(kind string, data []byte) (string, error) {
+	header := fmt.Sprintf("%s %d\x00", kind, len(data))
+	h := sha1.New()
+	h.Write([]byte(header))
+	h.Write(data)
+	id := hex.EncodeToString(h.Sum(nil))
+	path := filepath.Join(g.dir, "objects", id[:2], id[2:])
+	if _, err := os.Stat(path); err == nil {
+		return id, nil
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(header))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return id, os.WriteFile(path, buf.Bytes(), 0444)
+}
+
+// ref points a ref such as "refs/tags/v1" at an object
+func (g *gitWriter) ref(name string, id string) error {
+	path := filepath.Join(g.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(id+"\n"), 0644)
+}
+
+// gitDir is a directory being assembled into tree objects
+type gitDir struct {
+	files map[string]gitTreeEntry
+	dirs  map[string]*gitDir
+}
+
+func newGitDir() *gitDir {
+	return &gitDir{files: map[string]gitTreeEntry{}, dirs: map[string]*gitDir{}}
+}
+
+// add places a blob at a slash-separated path. A tree can't hold a file
+// and a folder with the same name, so that fails.
+func (d *gitDir) add(path string, entry gitTreeEntry) error {
+	parts := strings.Split(path, "/")
+	for i, part := range parts[:len(parts)-1] {
+		if _, ok := d.files[part]; ok {
+			return fmt.Errorf("%s is both a file and a folder", strings.Join(parts[:i+1], "/"))
+		}
+		if d.dirs[part] == nil {
+			d.dirs[part] = newGitDir()
+		}
+		d = d.dirs[part]
+	}
+	entry.name = parts[len(parts)-1]
+	if d.dirs[entry.name] != nil {
+		return fmt.Errorf("%s is both a file and a folder", path)
+	}
+	d.files[entry.name] = entry
+	return nil
+}
+
+// write stores the directory and everything below it as tree objects
+func (d *gitDir) write(g *gitWriter) (string, error) {
+	entries := []gitTreeEntry{}
+	for _, e := range d.files {
+		entries = append(entries, e)
+	}
+	for name, sub := range d.dirs {
+		id, err := sub.write(g)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, gitTreeEntry{mode: "40000", name: name, id: id})
+	}
+	// Git sorts tree entries as if directory names ended with a slash
+	sortKey := func(e gitTreeEntry) string {
+		if e.mode == "40000" {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortKey(entries[i]) < sortKey(entries[j])
+	})
+	var buf bytes.Buffer
+	for _, e := range entries {
+		id, _ := hex.DecodeString(e.id)
+		fmt.Fprintf(&buf, "%s %s\x00", e.mode, e.name)
+		buf.Write(id)
+	}
+	return g.object("tree", buf.Bytes())
+}
+
+// gitSignature formats an author and time the way commit objects need.
+// Authors without an email get an empty one.
+func gitSignature(author string, when time.Time) string {
+	if author == "" {
+		author = "Unknown"
+	}
+	if !strings.Contains(author, "<") {
+		author += " <>"
+	}
+	_, offset := when.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s %d %c%02d%02d", author, when.Unix(), sign, offset/3600, offset%3600/60)
+}
+
+// gitRefName turns a version tag into a valid git tag name
+func gitRefName(tag string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f || strings.ContainsRune("~^:?*[\\", r) {
+			return '-'
+		}
+		return r
+	}, tag)
+	name = strings.ReplaceAll(name, "..", "-")
+	name = strings.ReplaceAll(name, "@{", "-")
+	name = strings.Trim(name, "./")
+	name = strings.TrimSuffix(name, ".lock")
+	if name == "" || name == "@" {
+		name = "version"
+	}
+	return name
+}
+
+// ExportGit replays every version, oldest first, as a commit on the main
+// branch of a new git repository in dir, and tags each one with its
+// version tag
+func (zippy *Repo) ExportGit(dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return conflictError("%s already exists and is not empty", dir)
+	}
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		zippy.logf("No versions to export.\n")
+		return nil
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Timestamp.Before(versions[j].Timestamp)
+	})
+	g, err := newGitWriter(dir)
+	if err != nil {
+		return fmt.Errorf("failed to create git repository: %w", err)
+	}
+
+	zippy.logf("Exporting %d versions to %s...\n", len(versions), dir)
+	parent := ""
+	tagged := map[string]bool{}
+	for _, v := range versions {
+		commit, err := zippy.exportVersion(g, v, parent)
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", v.Tag, err)
+		}
+		name := gitRefName(v.Tag)
+		for base, n := name, 2; tagged[name]; n++ {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+		tagged[name] = true
+		if err := g.ref("refs/tags/"+name, commit); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", v.Tag, err)
+		}
+		zippy.logf("  Exported: %s -> %s\n", v.Tag, commit[:7])
+		parent = commit
+	}
+	if err := g.ref("refs/heads/main", parent); err != nil {
+		return err
+	}
+	zippy.logf("Export complete. Run 'git reset --hard' in %s to check out the newest version.\n", dir)
+	return nil
+}
+
+// exportVersion writes the files of a version as a git tree and commits it
+func (zippy *Repo) exportVersion(g *gitWriter, v Version, parent string) (string, error) {
+	zr, err := zippy.openVersionZip(v)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	root := newGitDir()
+	for _, f := range zr.File {
+		name := strings.Trim(filepath.ToSlash(f.Name), "/")
+		if f.FileInfo().IsDir() || name == "" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", f.Name, err)
+		}
+		id, err := g.object("blob", data)
+		if err != nil {
+			return "", err
+		}
+		mode := "100644"
+		if f.Mode()&0111 != 0 {
+			mode = "100755"
+		}
+		if err := root.add(name, gitTreeEntry{mode: mode, id: id}); err != nil {
+			return "", err
+		}
+	}
+	tree, err := root.write(g)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	if parent != "" {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	signature := gitSignature(FormatAuthor(v.Author, v.AuthorEmail), v.Timestamp)
+	fmt.Fprintf(&buf, "author %s\ncommitter %s\n\n", signature, signature)
+	message := strings.TrimRight(v.Message, "\n")
+	if message == "" {
+		message = v.Tag
+	}
+	buf.WriteString(message + "\n")
+	return g.object("commit", buf.Bytes())
+}
@@ -0,0 +1,148 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyGitDelta(t *testing.T) {
+	base := []byte("hello, world")
+	tests := []struct {
+		name  string
+		delta []byte
+		want  string // empty for a corrupt delta
+	}{
+		{"insert", []byte{12, 3, 3, 'a', 'b', 'c'}, "abc"},
+		{"copy all", []byte{12, 12, 0x90, 12}, "hello, world"},
+		{"copy with offset", []byte{12, 5, 0x91, 7, 5}, "world"},
+		{"copy and insert", []byte{12, 8, 0x90, 5, 3, '!', '!', '!'}, "hello!!!"},
+		{"wrong base size", []byte{11, 3, 3, 'a', 'b', 'c'}, ""},
+		{"wrong result size", []byte{12, 4, 3, 'a', 'b', 'c'}, ""},
+		{"copy past the base", []byte{12, 5, 0x91, 10, 5}, ""},
+		{"insert past the end", []byte{12, 3, 5, 'a', 'b'}, ""},
+		{"reserved command", []byte{12, 0, 0}, ""},
+		{"truncated copy", []byte{12, 5, 0x91, 7}, ""},
+		{"truncated header", []byte{0x80}, ""},
+	}
+	for _, tt := range tests {
+		got, err := applyGitDelta(base, tt.delta)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: applyGitDelta = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: applyGitDelta = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// gitPackIndex builds a version 2 pack index for objects at offsets, in
+// order, putting offsets of 2 GiB or more in the large offset table
+func gitPackIndex(offsets []int64) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2})
+	for i := 0; i < 256; i++ {
+		binary.Write(&buf, binary.BigEndian, uint32(len(offsets)))
+	}
+	for i := range offsets {
+		id := make([]byte, 20)
+		id[19] = byte(i)
+		buf.Write(id)
+	}
+	buf.Write(make([]byte, 4*len(offsets))) // CRC32s
+	large := []int64{}
+	for _, offset := range offsets {
+		if offset >= 1<<31 {
+			binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+			large = append(large, offset)
+		} else {
+			binary.Write(&buf, binary.BigEndian, uint32(offset))
+		}
+	}
+	for _, offset := range large {
+		binary.Write(&buf, binary.BigEndian, uint64(offset))
+	}
+	return buf.Bytes()
+}
+
+func TestOpenGitPack(t *testing.T) {
+	valid := gitPackIndex([]int64{12, 1 << 32})
+	tests := []struct {
+		name    string
+		index   []byte
+		offsets map[string]int64 // nil for an index that must be rejected
+	}{
+		{"small and large offsets", valid, map[string]int64{
+			"0000000000000000000000000000000000000000": 12,
+			"0000000000000000000000000000000000000001": 1 << 32,
+		}},
+		{"empty", gitPackIndex(nil), map[string]int64{}},
+		{"version 1", append([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 1}, valid[8:]...), nil},
+		{"truncated fanout", valid[:100], nil},
+		{"truncated offsets", valid[:len(valid)-16], nil},
+		{"truncated large offsets", valid[:len(valid)-4], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			index := filepath.Join(dir, "pack-1.idx")
+			os.WriteFile(index, tt.index, 0644)
+			os.WriteFile(filepath.Join(dir, "pack-1.pack"), nil, 0644)
+			pack, err := openGitPack(index)
+			if tt.offsets == nil {
+				if err == nil {
+					pack.file.Close()
+					t.Fatal("openGitPack accepted a bad index")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openGitPack: %v", err)
+			}
+			pack.file.Close()
+			if len(pack.offsets) != len(tt.offsets) {
+				t.Fatalf("offsets = %v, want %v", pack.offsets, tt.offsets)
+			}
+			for id, want := range tt.offsets {
+				if got := pack.offsets[id]; got != want {
+					t.Errorf("offset of %s = %d, want %d", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExportGitRejectsFileAndFolder(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries [][2]string
+		wantErr string
+	}{
+		{"distinct", [][2]string{{"a", "x"}, {"b/c", "y"}}, ""},
+		{"file then folder", [][2]string{{"a", "x"}, {"a/b", "y"}}, "a is both a file and a folder"},
+		{"folder then file", [][2]string{{"a/b", "y"}, {"a", "x"}}, "a is both a file and a folder"},
+		{"nested", [][2]string{{"a/b/c", "x"}, {"a/b", "y"}}, "a/b is both a file and a folder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zippy := newTestRepo(t)
+			storeVersion(t, zippy, "v1", zipArchive(t, tt.entries...))
+			err := zippy.ExportGit(filepath.Join(t.TempDir(), "git"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ExportGit: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ExportGit = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// GrepOptions select what Grep searches
+type GrepOptions struct {
+	Pattern   *regexp.Regexp
+	Tags      []string // versions to search, in this order; every version oldest first when empty
+	Paths     []string // files or folders to search; every file when empty
+	FilesOnly bool     // report only the first match of each file
+	Jobs      int      // versions searched at once; the number of CPUs when zero
+}
+
+// GrepMatch is a line of a file in a version that matches the pattern
+type GrepMatch struct {
+	Tag  string `json:"tag"`
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// grepResult is what a worker found in one version
+type grepResult struct {
+	matches []GrepMatch
+	err     error
+}
+
+// Grep searches the text files of version archives without extracting them
+// and calls fn for each match, in the order of the versions and then of the
+// files. Binary files are skipped.
+func (zippy *Repo) Grep(opts GrepOptions, fn func(GrepMatch) error) error {
+	var versions []Version
+	if len(opts.Tags) == 0 {
+		all, err := zippy.loadVersions()
+		if err != nil {
+			return err
+		}
+		sortVersions(all)
+		versions = all
+	}
+	for _, tag := range opts.Tags {
+		v, err := zippy.loadVersion(tag)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, v)
+	}
+	paths := []string{}
+	for _, p := range opts.Paths {
+		paths = append(paths, strings.Trim(path.Clean(strings.ReplaceAll(p, "\\", "/")), "/"))
+	}
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	// Reading the metadata above has unlocked an encrypted repository, so
+	// the workers never prompt for a passphrase
+	results := make([]chan grepResult, len(versions))
+	slots := make(chan struct{}, jobs)
+	done := make(chan struct{})
+	defer close(done)
+	for i, v := range versions {
+		results[i] = make(chan grepResult, 1)
+		go func() {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			defer func() { <-slots }()
+			matches, err := zippy.grepVersion(v, paths, opts)
+			results[i] <- grepResult{matches, err}
+		}()
+	}
+	for i := range versions {
+		result := <-results[i]
+		if result.err != nil {
+			return fmt.Errorf("failed to search %s: %w", versions[i].Tag, result.err)
+		}
+		for _, m := range result.matches {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// grepVersion searches the files of one version under paths
+func (zippy *Repo) grepVersion(v Version, paths []string, opts GrepOptions) ([]GrepMatch, error) {
+	zr, err := zippy.openVersionZip(v)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	matches := []GrepMatch{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !underAny(f.Name, paths) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		found, err := grepFile(rc, opts.Pattern, opts.FilesOnly)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		for _, m := range found {
+			m.Tag, m.Path = v.Tag, f.Name
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// grepFile returns the matching lines of a text file, or nothing for a
+// binary one. Lines too long to scan end the search of that file.
+func grepFile(r io.Reader, pattern *regexp.Regexp, first bool) ([]GrepMatch, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, err := br.Peek(8000)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if isBinary(head) {
+		return nil, nil
+	}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(nil, 1024*1024)
+	found := []GrepMatch{}
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+		if pattern.Match(text) {
+			found = append(found, GrepMatch{Line: line, Text: string(text)})
+			if first {
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, err
+	}
+	return found, nil
+}
+
+// underAny reports whether name is one of paths or inside one of them. No
+// paths means everything.
+func underAny(name string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// What happened to a file in a version
+const (
+	FILE_ADDED   = "added"
+	FILE_CHANGED = "changed"
+	FILE_RENAMED = "renamed"
+	FILE_REMOVED = "removed"
+)
+
+// FileEvent is a version that added, changed, renamed or removed a file
+type FileEvent struct {
+	Tag       string    `json:"tag"`
+	Previous  string    `json:"previous,omitempty"` // the version before, to compare with
+	Timestamp time.Time `json:"timestamp"`
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	Action    string    `json:"action"` // added, changed, renamed or removed
+	Path      string    `json:"path"`
+	OldPath   string    `json:"old_path,omitempty"` // the name before a rename
+	CRC32     uint32    `json:"crc32"`              // of the file after this version, zero when removed
+	Size      int64     `json:"size"`
+	SizeDelta int64     `json:"size_delta"`
+}
+
+// History walks the versions oldest first and returns each one that added,
+// changed, renamed or removed the file at p. A file that disappears while a
+// new file with the same contents appears is followed as a rename, in both
+// directions.
+func (zippy *Repo) History(p string) ([]FileEvent, error) {
+	p = strings.Trim(path.Clean(strings.ReplaceAll(p, "\\", "/")), "/")
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(versions)
+	files := make([]map[string]FileEntry, len(versions))
+	anchor := -1 // the newest version that has the file
+	for i, v := range versions {
+		entries, err := zippy.versionEntries(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", v.Tag, err)
+		}
+		files[i] = map[string]FileEntry{}
+		for _, e := range entries {
+			files[i][e.Path] = e
+		}
+		if _, ok := files[i][p]; ok {
+			anchor = i
+		}
+	}
+	if anchor < 0 {
+		return nil, notFoundError("%s is not in any version", p)
+	}
+
+	// Name the file in every version, following renames away from the anchor
+	names := make([]string, len(versions))
+	names[anchor] = p
+	for i := anchor - 1; i >= 0; i-- {
+		names[i] = renamedFrom(files[i+1], files[i], names[i+1])
+	}
+	for i := anchor + 1; i < len(versions); i++ {
+		names[i] = renamedFrom(files[i-1], files[i], names[i-1])
+	}
+
+	events := []FileEvent{}
+	for i, v := range versions {
+		event := FileEvent{Tag: v.Tag, Timestamp: v.Timestamp, Author: v.Author, Message: v.Message, Path: names[i]}
+		current, inCurrent := files[i][names[i]]
+		var before FileEntry
+		inBefore := false
+		if i > 0 {
+			event.Previous = versions[i-1].Tag
+			before, inBefore = files[i-1][names[i-1]]
+		}
+		switch {
+		case !inBefore && inCurrent:
+			event.Action = FILE_ADDED
+		case inBefore && !inCurrent:
+			event.Action, event.Path = FILE_REMOVED, names[i-1]
+		case inBefore && names[i] != names[i-1]:
+			event.Action, event.OldPath = FILE_RENAMED, names[i-1]
+		case inBefore && current.CRC32 != before.CRC32:
+			event.Action = FILE_CHANGED
+		default:
+			continue
+		}
+		event.CRC32, event.Size = current.CRC32, current.Size
+		event.SizeDelta = current.Size - before.Size
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// renamedFrom returns the name in next of the file called name in known.
+// That is the same name unless the file left next's side while a file with
+// the same contents, absent on known's side, appeared.
+func renamedFrom(known map[string]FileEntry, next map[string]FileEntry, name string) string {
+	file, ok := known[name]
+	if !ok {
+		return name
+	}
+	if _, ok := next[name]; ok {
+		return name
+	}
+	candidates := []string{}
+	for other, e := range next {
+		if _, existed := known[other]; !existed && e.CRC32 == file.CRC32 && e.Size == file.Size {
+			candidates = append(candidates, other)
+		}
+	}
+	if len(candidates) == 0 {
+		return name
+	}
+	// Several copies appeared; take the first name so the result is stable
+	return slices.Min(candidates)
+}
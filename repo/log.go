@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogOptions select and order the versions VersionLog returns. Zero values
+// don't filter.
+type LogOptions struct {
+	Since   time.Time
+	Until   time.Time
+	Author  string         // part of the author name or email, ignoring case
+	Grep    *regexp.Regexp // matched against the message
+	Path    string         // a file or folder the version added, changed or removed
+	Limit   int            // at most this many, the newest ones
+	Reverse bool           // oldest first instead of newest first
+}
+
+// VersionLog returns the versions that match opts, newest first
+func (zippy *Repo) VersionLog(opts LogOptions) ([]Version, error) {
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(versions)
+	touched := map[string]bool{}
+	if opts.Path != "" {
+		if touched, err = zippy.versionsTouching(versions, opts.Path); err != nil {
+			return nil, err
+		}
+	}
+	author := strings.ToLower(opts.Author)
+	result := []Version{}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		switch {
+		case !opts.Since.IsZero() && v.Timestamp.Before(opts.Since):
+		case !opts.Until.IsZero() && v.Timestamp.After(opts.Until):
+		case author != "" && !strings.Contains(strings.ToLower(FormatAuthor(v.Author, v.AuthorEmail)), author):
+		case opts.Grep != nil && !opts.Grep.MatchString(v.Message):
+		case opts.Path != "" && !touched[v.Tag]:
+		default:
+			result = append(result, v)
+		}
+		if opts.Limit > 0 && len(result) == opts.Limit {
+			break
+		}
+	}
+	if opts.Reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+	return result, nil
+}
+
+// sortVersions orders versions oldest first, by timestamp and then tag
+func sortVersions(versions []Version) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if !versions[i].Timestamp.Equal(versions[j].Timestamp) {
+			return versions[i].Timestamp.Before(versions[j].Timestamp)
+		}
+		return versions[i].Tag < versions[j].Tag
+	})
+}
+
+// versionsTouching finds the versions, given oldest first, whose files
+// under p differ from those of the version before
+func (zippy *Repo) versionsTouching(versions []Version, p string) (map[string]bool, error) {
+	p = strings.Trim(path.Clean(strings.ReplaceAll(p, "\\", "/")), "/")
+	under := func(name string) bool {
+		return p == "." || name == p || strings.HasPrefix(name, p+"/")
+	}
+	touched := map[string]bool{}
+	previous := map[string]uint32{}
+	for _, v := range versions {
+		files, err := zippy.versionFiles(v)
+		if err != nil {
+			return nil, err
+		}
+		current := map[string]uint32{}
+		for name, crc := range files {
+			if under(name) {
+				current[name] = crc
+			}
+		}
+		if !compareFiles(previous, current).Empty() {
+			touched[v.Tag] = true
+		}
+		previous = current
+	}
+	return touched, nil
+}
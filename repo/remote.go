@@ -0,0 +1,367 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RemoteConfig is a named repository to push to and pull from
+type RemoteConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"` // path of another repository or a bare repository directory, or an http(s) URL
+}
+
+// Remote is a repository that versions can be transferred to and from
+type Remote interface {
+	Config() (RepoConfig, error)
+	Versions() ([]Version, error)
+	Fetch(v Version) (io.ReadCloser, error)   // the zip archive of v
+	Store(v Version, archive io.Reader) error // adds v with the given archive
+}
+
+// remoteURL resolves a remote name from config.json. Anything else is taken
+// as a location, so 'zippy push /mnt/backup' works without 'remote add'.
+func (zippy *Repo) remoteURL(name string) string {
+	for _, r := range zippy.config.Remotes {
+		if r.Name == name {
+			return r.URL
+		}
+	}
+	return name
+}
+
+// openRemote opens the repository at url, which may also be an http(s) URL
+// or a bundle file. With a template config, a missing or empty directory is
+// initialized as a bare repository.
+func (zippy *Repo) openRemote(url string, template *RepoConfig) (Remote, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return newHTTPRemote(url)
+	}
+	if info, err := os.Stat(url); err == nil && !info.IsDir() {
+		return openBundle(url)
+	}
+	path, err := filepath.Abs(url)
+	if err != nil {
+		return nil, err
+	}
+	// A working copy keeps its metadata in .zippy; a bare repository is
+	// just the metadata directory
+	zippyPath := filepath.Join(path, ".zippy")
+	if info, err := os.Stat(zippyPath); err != nil || !info.IsDir() {
+		zippyPath = path
+	}
+	if _, err := os.Stat(filepath.Join(zippyPath, "config.json")); err != nil {
+		entries, _ := os.ReadDir(path)
+		if template == nil || len(entries) > 0 {
+			return nil, &kindError{kind: ErrNotRepo, err: fmt.Errorf("%s is not a Zippy repository", url)}
+		}
+		if err := createRepo(zippyPath, *template); err != nil {
+			return nil, err
+		}
+	}
+	remote := &Repo{Passphrase: zippy.Passphrase}
+	if err := remote.openRepo(path, zippyPath); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return &localRemote{zippy: remote}, nil
+}
+
+// localRemote is a repository on this machine or a mounted drive
+type localRemote struct {
+	zippy *Repo
+}
+
+func (r *localRemote) Config() (RepoConfig, error) {
+	return r.zippy.config, nil
+}
+
+func (r *localRemote) Versions() ([]Version, error) {
+	return r.zippy.loadVersions()
+}
+
+func (r *localRemote) Fetch(v Version) (io.ReadCloser, error) {
+	return r.zippy.readArchive(v)
+}
+
+func (r *localRemote) Store(v Version, archive io.Reader) error {
+	if err := validTag(v.Tag); err != nil {
+		return err
+	}
+	name := v.Tag + ".zip"
+	counter := &countingReader{r: archive}
+	if err := r.zippy.storage.Put(name, counter); err != nil {
+		return err
+	}
+	v.ZipPath = name
+	v.Size = counter.n
+	v.PackPath = ""
+	v.PackOffset = 0
+	// Check the entries before the version exists, so a restore never
+	// sees an archive that writes outside the working copy
+	zr, err := r.zippy.openVersionZip(v)
+	if err == nil {
+		err = checkArchiveEntries(zr.File)
+		zr.Close()
+	}
+	if err != nil {
+		r.zippy.storage.Delete(name)
+		return fmt.Errorf("rejected archive: %w", err)
+	}
+	return r.zippy.saveVersionInfo(v)
+}
+
+// readArchive streams the zip archive of a version, cutting it out of its
+// pack file if needed
+func (zippy *Repo) readArchive(v Version) (io.ReadCloser, error) {
+	rc, err := zippy.storage.Get(zippy.archiveName(v))
+	if err != nil {
+		return nil, err
+	}
+	if v.PackPath == "" {
+		return rc, nil
+	}
+	if _, err := io.CopyN(io.Discard, rc, v.PackOffset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, v.Size), rc}, nil
+}
+
+// transfer copies the versions of src that dst does not have, oldest
+// first. With tags, only those versions are considered. A tag that exists
+// on both sides with a different timestamp is a conflict and is skipped.
+func (zippy *Repo) transfer(src Remote, dst Remote, tags []string, verb string) (int, error) {
+	srcVersions, err := src.Versions()
+	if err != nil {
+		return 0, err
+	}
+	dstVersions, err := dst.Versions()
+	if err != nil {
+		return 0, err
+	}
+	have := map[string]Version{}
+	for _, v := range dstVersions {
+		have[v.Tag] = v
+	}
+	wanted := map[string]bool{}
+	for _, tag := range tags {
+		wanted[tag] = true
+	}
+	selected := []Version{}
+	for _, v := range srcVersions {
+		if len(tags) == 0 || wanted[v.Tag] {
+			selected = append(selected, v)
+			delete(wanted, v.Tag)
+		}
+	}
+	for tag := range wanted {
+		return 0, notFoundError("version %s not found", tag)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Timestamp.Before(selected[j].Timestamp)
+	})
+
+	copied, conflicts := 0, 0
+	for _, v := range selected {
+		if existing, ok := have[v.Tag]; ok {
+			if !existing.Timestamp.Equal(v.Timestamp) {
+				zippy.logf("  [Conflict: %s is a different version on each side, skipped]\n", v.Tag)
+				conflicts++
+			}
+			continue
+		}
+		archive, err := src.Fetch(v)
+		if err != nil {
+			return copied, fmt.Errorf("%s: %w", v.Tag, err)
+		}
+		err = dst.Store(v, archive)
+		archive.Close()
+		if err != nil {
+			return copied, fmt.Errorf("%s: %w", v.Tag, err)
+		}
+		zippy.logf("  %s: %s\n", verb, v.Tag)
+		copied++
+	}
+	if conflicts > 0 {
+		return copied, conflictError("%d versions differ on each side and were skipped", conflicts)
+	}
+	return copied, nil
+}
+
+// AddRemote registers a remote in config.json
+func (zippy *Repo) AddRemote(name string, url string) error {
+	for _, r := range zippy.config.Remotes {
+		if r.Name == name {
+			return conflictError("remote %s already exists", name)
+		}
+	}
+	if abs, err := filepath.Abs(url); err == nil && !strings.Contains(url, "://") {
+		url = abs
+	}
+	zippy.config.Remotes = append(zippy.config.Remotes, RemoteConfig{Name: name, URL: url})
+	if err := zippy.saveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	zippy.logf("Added remote %s -> %s\n", name, url)
+	return nil
+}
+
+// RemoveRemote deletes a remote from config.json
+func (zippy *Repo) RemoveRemote(name string) error {
+	for i, r := range zippy.config.Remotes {
+		if r.Name == name {
+			zippy.config.Remotes = append(zippy.config.Remotes[:i], zippy.config.Remotes[i+1:]...)
+			if err := zippy.saveConfig(); err != nil {
+				return fmt.Errorf("failed to save config: %w", err)
+			}
+			zippy.logf("Removed remote %s\n", name)
+			return nil
+		}
+	}
+	return notFoundError("no remote named %s", name)
+}
+
+// Remotes returns the remotes in config.json
+func (zippy *Repo) Remotes() []RemoteConfig {
+	return zippy.config.Remotes
+}
+
+// sharedConfig returns the settings a new copy of a repository takes over:
+// those that describe the project, not where the source keeps its files.
+// Trusted keys are never copied: a remote must not choose whose signatures
+// this copy accepts, so trust is only added with 'zippy key trust'.
+// An encrypted source stays encrypted with the same passphrase or key file,
+// so pushing to a fresh directory never writes plaintext.
+func sharedConfig(src RepoConfig) RepoConfig {
+	config := RepoConfig{
+		Name:              src.Name,
+		Author:            src.Author,
+		Email:             src.Email,
+		Created:           time.Now(),
+		Description:       src.Description,
+		CompressionMethod: src.CompressionMethod,
+		CompressionLevel:  src.CompressionLevel,
+		FormatVersion:     ZIPPY_FORMAT,
+	}
+	if src.Encryption != nil {
+		encryption := *src.Encryption
+		encryption.PreviousWrappedKey = ""
+		config.Encryption = &encryption
+	}
+	return config
+}
+
+// CloneOptions are the callbacks of the new repository, as on Repo
+type CloneOptions struct {
+	Log        io.Writer
+	Passphrase func(prompt string, confirm bool) ([]byte, error)
+}
+
+// Clone creates a working copy in dst with every version of src and the
+// files of its newest version. src is saved as remote "origin".
+func Clone(src string, dst string, opts CloneOptions) (*Repo, error) {
+	zippy := &Repo{Log: opts.Log, Passphrase: opts.Passphrase}
+	remote, err := zippy.openRemote(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(dst); err == nil && len(entries) > 0 {
+		return nil, conflictError("%s already exists and is not empty", dst)
+	}
+	srcConfig, err := remote.Config()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote config: %w", err)
+	}
+	if abs, err := filepath.Abs(src); err == nil && !strings.Contains(src, "://") {
+		src = abs
+	}
+
+	config := sharedConfig(srcConfig)
+	config.Remotes = []RemoteConfig{{Name: "origin", URL: src}}
+	zippyPath := filepath.Join(dst, ".zippy")
+	if err := createRepo(zippyPath, config); err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+	if err := zippy.openRepo(dst, zippyPath); err != nil {
+		return nil, err
+	}
+	zippy.logf("Cloning %s into %s...\n", src, dst)
+	copied, err := zippy.transfer(remote, &localRemote{zippy: zippy}, nil, "Fetched")
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := zippy.loadVersions()
+	if err == nil && len(versions) > 0 {
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].Timestamp.Before(versions[j].Timestamp)
+		})
+		newest := versions[len(versions)-1].Tag
+		results, err := zippy.Restore(RestoreOptions{Tag: newest})
+		if err != nil {
+			return nil, err
+		}
+		zippy.logf("Restored %d files of %s\n", len(results), newest)
+	}
+	zippyignorePath := filepath.Join(dst, ".zippyignore")
+	if _, err := os.Stat(zippyignorePath); os.IsNotExist(err) {
+		os.WriteFile(zippyignorePath, []byte(defaultZippyIgnore), 0644)
+	}
+	zippy.logf("Cloned %d versions. Remote 'origin' points at %s\n", copied, src)
+	return zippy, nil
+}
+
+// Push sends the versions a remote is missing. With tags, only those
+// versions are sent. name is a remote in config.json or a location.
+func (zippy *Repo) Push(name string, tags []string) error {
+	url := zippy.remoteURL(name)
+	template := sharedConfig(zippy.config)
+	remote, err := zippy.openRemote(url, &template)
+	if err != nil {
+		return err
+	}
+	zippy.logf("Pushing to %s...\n", url)
+	sent, err := zippy.transfer(&localRemote{zippy: zippy}, remote, tags, "Pushed")
+	if err != nil {
+		return err
+	}
+	if sent == 0 {
+		zippy.logf("Everything up-to-date.\n")
+		return nil
+	}
+	zippy.logf("Push complete. %d versions sent.\n", sent)
+	return nil
+}
+
+// Pull fetches the versions this repository is missing from a remote. It
+// does not touch the working copy; use Restore for that.
+func (zippy *Repo) Pull(name string, tags []string) error {
+	url := zippy.remoteURL(name)
+	remote, err := zippy.openRemote(url, nil)
+	if err != nil {
+		return err
+	}
+	zippy.logf("Pulling from %s...\n", url)
+	received, err := zippy.transfer(remote, &localRemote{zippy: zippy}, tags, "Pulled")
+	if err != nil {
+		return err
+	}
+	if received == 0 {
+		zippy.logf("Already up-to-date.\n")
+		return nil
+	}
+	zippy.logf("Pull complete. %d versions received.\n", received)
+	return nil
+}
@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsLocalEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		local bool
+	}{
+		{"a.txt", true},
+		{"src/app.go", true},
+		{"src/", true},
+		{"src\\app.go", true},
+		{"a..b/c", true},
+		{"", false},
+		{"../x", false},
+		{"a/../../x", false},
+		{"..\\x", false},
+		{"/etc/passwd", false},
+		{"\\x", false},
+		{"C:/x", false},
+		{"c:x", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalEntry(tt.name); got != tt.local {
+			t.Errorf("isLocalEntry(%q) = %v, want %v", tt.name, got, tt.local)
+		}
+	}
+}
+
+func TestRestoreRejectsUnsafePaths(t *testing.T) {
+	zippy := newTestRepo(t)
+	storeVersion(t, zippy, "v1", zipArchive(t, [2]string{"a.txt", "a"}, [2]string{"../pwned.txt", "owned"}))
+
+	results, err := zippy.Restore(RestoreOptions{Tag: "v1"})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Restore error = %v, want ErrInvalid", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(zippy.Root()), "pwned.txt")); !os.IsNotExist(err) {
+		t.Fatalf("entry escaped the working copy: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(zippy.Root(), "a.txt")); err != nil || string(data) != "a" {
+		t.Errorf("a.txt = %q, %v; want the safe entries restored", data, err)
+	}
+	if len(results) != 2 || results[1].Status != "failed" {
+		t.Errorf("results = %+v, want a.txt restored and ../pwned.txt failed", results)
+	}
+}
+
+func TestLocalRemoteStoreChecksEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries [][2]string
+		ok      bool
+	}{
+		{"plain", [][2]string{{"a.txt", "a"}, {"src/b.go", "b"}}, true},
+		{"parent", [][2]string{{"../x", "x"}}, false},
+		{"absolute", [][2]string{{"/tmp/x", "x"}}, false},
+		{"duplicate", [][2]string{{"a.txt", "a"}, {"a.txt", "b"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zippy := newTestRepo(t)
+			remote := &localRemote{zippy: zippy}
+			err := remote.Store(Version{Tag: "v1"}, bytes.NewReader(zipArchive(t, tt.entries...)))
+			if tt.ok != (err == nil) {
+				t.Fatalf("Store error = %v, want ok=%v", err, tt.ok)
+			}
+			_, verr := zippy.loadVersion("v1")
+			if tt.ok != (verr == nil) {
+				t.Errorf("version stored = %v, want %v", verr == nil, tt.ok)
+			}
+			if !tt.ok {
+				if _, err := zippy.storage.Stat("v1.zip"); err == nil {
+					t.Errorf("rejected archive left in storage")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+// Package repo implements Zippy repositories: versions of a project kept as
+// zip archives in .zippy, with staging, restore, diff and status, storage
+// backends, encryption, signatures, remotes and imports from other tools.
+// The zippy command is a thin layer on top of it.
+//
+// A program opens a working copy and works with its versions:
+//
+//	r, err := repo.Open("/path/to/project")
+//	if err != nil {
+//		return err
+//	}
+//	if _, err := r.Stage([]string{"."}); err != nil {
+//		return err
+//	}
+//	v, err := r.Commit(repo.CommitOptions{Message: "Nightly", Tag: "nightly-42"})
+//
+// Errors can be told apart with errors.Is and ErrNotRepo, ErrInvalid,
+// ErrNotFound and ErrConflict.
+package repo
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ZIPPY_STAGE = "stage.json"
+	ZIPPY_PACKS = "packs"
+
+	// Metadata format written by this version. Format 1 stores archive
+	// paths relative to the storage directory.
+	ZIPPY_FORMAT = 1
+
+	// Archives smaller than this are consolidated into pack files by gc
+	ZIPPY_PACK_THRESHOLD = 64 * 1024
+
+	// Compression methods accepted in config.json and by --method
+	COMPRESSION_DEFLATE = "deflate"
+	COMPRESSION_ZSTD    = "zstd"
+	COMPRESSION_STORE   = "store"
+
+	// Storage backends accepted in config.json
+	STORAGE_LOCAL = "local"
+	STORAGE_S3    = "s3"
+
+	// Ways to unlock an encrypted repository
+	ENCRYPTION_PASSPHRASE = "passphrase"
+	ENCRYPTION_KEYFILE    = "keyfile"
+
+	// Encrypted file layout and passphrase stretching
+	ENCRYPTION_FORMAT     = 1
+	ENCRYPTION_HEADER     = 24        // magic, format, key id, nonce prefix
+	ENCRYPTION_CHUNK      = 64 * 1024 // plaintext bytes per sealed chunk
+	ENCRYPTION_ITERATIONS = 600000
+)
+
+// storedExtensions are formats that are already compressed. They are
+// stored as-is, since compressing them again only costs CPU.
+var storedExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".mp4": true, ".m4a": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true,
+	".avi": true, ".ogg": true, ".flac": true, ".aac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".woff": true, ".woff2": true,
+}
+
+func init() {
+	// Zstandard is not built into archive/zip. Registering it globally lets
+	// every reader in zippy open zstd entries written by any writer.
+	zip.RegisterCompressor(zstd.ZipMethodWinZip, zstd.ZipCompressor())
+	zip.RegisterDecompressor(zstd.ZipMethodWinZip, zstd.ZipDecompressor())
+}
+
+// Version represents a version entry
+type Version struct {
+	Tag         string    `json:"tag"`
+	Message     string    `json:"message"`
+	Timestamp   time.Time `json:"timestamp"`
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email,omitempty"`
+	FilesCount  int       `json:"files_count"`
+	ZipPath     string    `json:"zip_path"` // relative to the storage directory
+	Size        int64     `json:"size"`
+	PackPath    string    `json:"pack_path,omitempty"`
+	PackOffset  int64     `json:"pack_offset,omitempty"`
+	SignedBy    string    `json:"signed_by,omitempty"` // public key of the signer
+	Signature   string    `json:"signature,omitempty"` // base64 Ed25519 signature of the version manifest
+}
+
+// Repository configuration
+type RepoConfig struct {
+	Name              string            `json:"name"`
+	Author            string            `json:"author"`
+	Email             string            `json:"email,omitempty"` // of the author
+	Created           time.Time         `json:"created"`
+	Description       string            `json:"description"`
+	CompressionMethod string            `json:"compression_method,omitempty"` // deflate (default), zstd or store
+	CompressionLevel  int               `json:"compression_level,omitempty"`  // 1 (fastest) to 9 (best), 22 for zstd; 0 means default
+	Storage           *StorageConfig    `json:"storage,omitempty"`
+	FormatVersion     int               `json:"format_version,omitempty"`
+	Encryption        *EncryptionConfig `json:"encryption,omitempty"`
+	TrustedKeys       []TrustedKey      `json:"trusted_keys,omitempty"`
+	Remotes           []RemoteConfig    `json:"remotes,omitempty"`
+}
+
+// StorageConfig selects where version archives are kept. Without it they
+// live in .zippy/storage. S3 credentials come from the environment, never
+// from the config file.
+type StorageConfig struct {
+	Type     string `json:"type"`               // local (default) or s3
+	Path     string `json:"path,omitempty"`     // local: storage directory, relative to the project folder
+	Endpoint string `json:"endpoint,omitempty"` // s3: e.g. https://s3.amazonaws.com or http://localhost:9000
+	Bucket   string `json:"bucket,omitempty"`   // s3: bucket name
+	Prefix   string `json:"prefix,omitempty"`   // s3: key prefix inside the bucket
+	Region   string `json:"region,omitempty"`   // s3: defaults to us-east-1
+}
+
+// EncryptionConfig describes how the data key of an encrypted repository is
+// protected. The data key itself is random; config.json only holds it
+// wrapped with a key derived from the passphrase or key file.
+type EncryptionConfig struct {
+	Mode               string `json:"mode"`                           // passphrase or keyfile
+	KeyFile            string `json:"key_file,omitempty"`             // keyfile: absolute path to the key file
+	Salt               string `json:"salt"`                           // base64
+	Iterations         int    `json:"iterations,omitempty"`           // passphrase: PBKDF2-SHA256 rounds
+	WrappedKey         string `json:"wrapped_key"`                    // base64 AES-GCM nonce and sealed data key
+	PreviousWrappedKey string `json:"previous_wrapped_key,omitempty"` // only set while a key rotation is unfinished
+}
+
+// TrustedKey is a signing key whose versions 'zippy verify --signatures'
+// accepts
+type TrustedKey struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"` // ed25519:<base64>
+}
+
+// ZippyIgnore handles .zippyignore file parsing
+type ZippyIgnore struct {
+	patterns []string
+}
+
+// Repo is an open repository. Log and Passphrase may be set after Open.
+type Repo struct {
+	// Log receives progress messages from long operations such as Push, GC
+	// and ImportGit. Nothing is written when it is nil.
+	Log io.Writer
+	// Passphrase is called when an encrypted repository needs a passphrase
+	// that is not in the environment. confirm asks for it twice, for a new
+	// passphrase. Without it such repositories only open with the variable
+	// set.
+	Passphrase func(prompt string, confirm bool) ([]byte, error)
+
+	repoPath     string
+	zippyPath    string
+	configPath   string
+	versionsPath string
+	storagePath  string
+	config       RepoConfig
+	user         UserConfig
+	stagePath    string
+	storage      Storage
+	keys         *repoKeys
+	unlockErr    error
+}
+
+// Errors returned by this package match one of these with errors.Is.
+// ErrNotFound and ErrConflict also match fs.ErrNotExist and fs.ErrExist.
+var (
+	ErrNotRepo  = errors.New("not a Zippy repository. Run 'zippy init' first")
+	ErrInvalid  = errors.New("invalid argument")
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+	// ErrNoPassphrase is what a Passphrase callback returns when it can't ask
+	ErrNoPassphrase = errors.New("cannot prompt for a passphrase")
+)
+
+// kindError is an error with its own message that matches one of the
+// errors above
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+func (e *kindError) Unwrap() error { return e.err }
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind || (e.kind == ErrNotFound && target == fs.ErrNotExist) || (e.kind == ErrConflict && target == fs.ErrExist)
+}
+
+func invalidError(format string, args ...any) error {
+	return &kindError{kind: ErrInvalid, err: fmt.Errorf(format, args...)}
+}
+
+func notFoundError(format string, args ...any) error {
+	return &kindError{kind: ErrNotFound, err: fmt.Errorf(format, args...)}
+}
+
+func conflictError(format string, args ...any) error {
+	return &kindError{kind: ErrConflict, err: fmt.Errorf(format, args...)}
+}
+
+// logf writes a progress message to Log
+func (zippy *Repo) logf(format string, args ...any) {
+	logf(zippy.Log, format, args...)
+}
+
+// logf writes a progress message to w unless it is nil
+func logf(w io.Writer, format string, args ...any) {
+	if w != nil {
+		fmt.Fprintf(w, format, args...)
+	}
+}
+
+// Open opens the working copy at path, whose metadata is in path/.zippy
+func Open(path string) (*Repo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	zippy := &Repo{}
+	if err := zippy.openRepo(path, filepath.Join(path, ".zippy")); err != nil {
+		return nil, err
+	}
+	return zippy, nil
+}
+
+// Find opens the working copy that dir is in: the nearest of dir and its
+// parents that has a .zippy folder
+func Find(dir string) (*Repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if info, err := os.Stat(filepath.Join(dir, ".zippy")); err == nil && info.IsDir() {
+			return Open(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, ErrNotRepo
+		}
+		dir = parent
+	}
+}
+
+// Root returns the directory of the working copy
+func (zippy *Repo) Root() string {
+	return zippy.repoPath
+}
+
+// Config returns the repository configuration
+func (zippy *Repo) Config() RepoConfig {
+	return zippy.config
+}
+
+// openRepo opens the repository whose metadata is in zippyPath. repoPath is
+// the working copy; for a bare repository both are the same directory.
+func (zippy *Repo) openRepo(repoPath string, zippyPath string) error {
+	zippy.repoPath = repoPath
+	zippy.zippyPath = zippyPath
+	zippy.configPath = filepath.Join(zippy.zippyPath, "config.json")
+	zippy.versionsPath = filepath.Join(zippy.zippyPath, "versions")
+	zippy.storagePath = filepath.Join(zippy.zippyPath, "storage")
+	zippy.stagePath = filepath.Join(zippy.zippyPath, ZIPPY_STAGE)
+
+	// Check if repository is initialized
+	if _, err := os.Stat(zippy.zippyPath); os.IsNotExist(err) {
+		return ErrNotRepo
+	}
+
+	// Load config if it exists
+	if configData, err := os.ReadFile(zippy.configPath); err == nil {
+		json.Unmarshal(configData, &zippy.config)
+	}
+	user, err := LoadUserConfig()
+	if err != nil {
+		return err
+	}
+	zippy.user = user
+
+	if cfg := zippy.config.Storage; cfg != nil && cfg.Path != "" && (cfg.Type == "" || cfg.Type == STORAGE_LOCAL) {
+		zippy.storagePath = cfg.Path
+		if !filepath.IsAbs(cfg.Path) {
+			zippy.storagePath = filepath.Join(zippy.repoPath, cfg.Path)
+		}
+	}
+	storage, err := zippy.openStorage()
+	if err != nil {
+		return err
+	}
+	zippy.storage = storage
+	if zippy.config.Encryption != nil {
+		zippy.storage = &encryptedStorage{Storage: storage, zippy: zippy}
+	}
+
+	if err := zippy.migrate(); err != nil {
+		return fmt.Errorf("failed to upgrade repository metadata: %v", err)
+	}
+
+	return nil
+}
+
+// migrate upgrades metadata written by older versions of zippy. Format 0
+// stored absolute archive paths, which broke once the project folder was
+// moved or copied to another machine.
+func (zippy *Repo) migrate() error {
+	if zippy.config.FormatVersion >= ZIPPY_FORMAT {
+		return nil
+	}
+	files, err := os.ReadDir(zippy.versionsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	migrated := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		v, err := zippy.loadVersion(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		zipPath, packPath := v.ZipPath, v.PackPath
+		if v.ZipPath != "" {
+			v.ZipPath = zippy.storageRel(v.ZipPath)
+		}
+		if v.PackPath != "" {
+			v.PackPath = zippy.storageRel(v.PackPath)
+		}
+		if v.ZipPath != zipPath || v.PackPath != packPath {
+			if err := zippy.saveVersionInfo(v); err != nil {
+				return err
+			}
+			migrated++
+		}
+	}
+	zippy.config.FormatVersion = ZIPPY_FORMAT
+	if err := zippy.saveConfig(); err != nil {
+		return err
+	}
+	if migrated > 0 {
+		zippy.logf("Migrated %d versions to relative storage paths.\n", migrated)
+	}
+	return nil
+}
+
+// saveConfig writes the repository configuration to config.json
+func (zippy *Repo) saveConfig() error {
+	data, err := json.MarshalIndent(zippy.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(zippy.configPath, data, 0644)
+}
+
+// InitOptions describe a new repository. Empty fields get defaults.
+type InitOptions struct {
+	Name        string // default: the name of the directory
+	Author      string // default: Unknown
+	Email       string
+	Description string   // default: Zippy Repository
+	Templates   []string // ignore templates added to .zippyignore, see IgnoreTemplate
+	Encryption  string   // passphrase or keyfile to encrypt stored versions
+	KeyFile     string   // keyfile: path of the key file, created if missing
+	Log         io.Writer
+	Passphrase  func(prompt string, confirm bool) ([]byte, error)
+}
+
+// Init creates a repository in path, writes a .zippyignore with the default
+// patterns and those of the templates, and opens the new repository
+func Init(path string, opts InitOptions) (*Repo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	zippy := &Repo{Log: opts.Log, Passphrase: opts.Passphrase}
+	zippyPath := filepath.Join(path, ".zippy")
+
+	// Check if already initialized
+	if _, err := os.Stat(zippyPath); !os.IsNotExist(err) {
+		return nil, conflictError("Zippy repository already initialized")
+	}
+	if opts.Name == "" {
+		opts.Name = filepath.Base(path)
+	}
+	if opts.Author == "" {
+		opts.Author = "Unknown"
+	}
+	if opts.Description == "" {
+		opts.Description = "Zippy Repository"
+	}
+	zippyIgnore, err := zippyIgnoreFor(opts.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up encryption before anything is written, so a wrong passphrase
+	// or unreadable key file leaves no half-initialized repository behind
+	var encryptionConfig *EncryptionConfig
+	if opts.Encryption != "" {
+		var secret []byte
+		keyFile := opts.KeyFile
+		switch opts.Encryption {
+		case ENCRYPTION_KEYFILE:
+			if keyFile, err = filepath.Abs(keyFile); err == nil {
+				secret, err = zippy.loadOrCreateKeyFile(keyFile)
+			}
+		case ENCRYPTION_PASSPHRASE:
+			secret, err = zippy.passphrase("Passphrase: ", "ZIPPY_PASSPHRASE", true)
+		default:
+			return nil, invalidError("unknown encryption mode %q (use passphrase or keyfile)", opts.Encryption)
+		}
+		if err == nil {
+			encryptionConfig, _, err = newEncryptionConfig(opts.Encryption, keyFile, secret, randomBytes(32))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up encryption: %w", err)
+		}
+	}
+
+	// Create directories and config
+	config := RepoConfig{
+		Name:          opts.Name,
+		Author:        opts.Author,
+		Email:         opts.Email,
+		Created:       time.Now(),
+		Description:   opts.Description,
+		FormatVersion: ZIPPY_FORMAT,
+		Encryption:    encryptionConfig,
+	}
+	if err := createRepo(zippyPath, config); err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	// Create sample .zippyignore
+	zippyignorePath := filepath.Join(path, ".zippyignore")
+	os.WriteFile(zippyignorePath, []byte(zippyIgnore), 0644)
+
+	if err := zippy.openRepo(path, zippyPath); err != nil {
+		return nil, err
+	}
+	return zippy, nil
+}
+
+// createRepo lays out the metadata directory of a new repository
+func createRepo(zippyPath string, config RepoConfig) error {
+	dirs := []string{zippyPath, filepath.Join(zippyPath, "versions"), filepath.Join(zippyPath, "storage")}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	configData, _ := json.MarshalIndent(config, "", "  ")
+	return os.WriteFile(filepath.Join(zippyPath, "config.json"), configData, 0644)
+}
+
+// defaultZippyIgnore is the .zippyignore written by init
+const defaultZippyIgnore = `# Zippy ignore file
+# Ignore version control directory
+.zippy/
+
+# Common files to ignore
+*.log
+*.tmp
+.DS_Store
+Thumbs.db
+node_modules/
+*.exe
+*.dll
+*.so
+.env
+.env.local
+
+# Add your patterns here
+`
+
+func (zippy *Repo) loadZippyIgnore() *ZippyIgnore {
+	zippyignore := &ZippyIgnore{}
+	zippyignorePath := filepath.Join(zippy.repoPath, ".zippyignore")
+
+	file, err := os.Open(zippyignorePath)
+	if err != nil {
+		return zippyignore // Return empty if file doesn't exist
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Skip empty lines and comments
+		if line != "" && !strings.HasPrefix(line, "#") {
+			zippyignore.patterns = append(zippyignore.patterns, line)
+		}
+	}
+
+	return zippyignore
+}
+
+func (zippyignore *ZippyIgnore) shouldIgnore(filePath string) bool {
+	// Normalize filePath to use forward slashes for matching
+	filePath = filepath.ToSlash(filePath)
+	for _, pattern := range zippyignore.patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		// Normalize pattern
+		pattern = filepath.ToSlash(pattern)
+		// Directory pattern (ends with /)
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(filePath, pattern) {
+				return true
+			}
+			continue
+		}
+		// Glob pattern
+		matched, err := filepath.Match(pattern, filePath)
+		if err == nil && matched {
+			return true
+		}
+		// Also match against just the base name (like .gitignore does)
+		if matched, err := filepath.Match(pattern, filepath.Base(filePath)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestRepo creates an empty repository in a temporary folder, with the
+// user config and identity isolated from the machine running the tests
+func newTestRepo(t *testing.T) *Repo {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("ZIPPY_AUTHOR_NAME", "")
+	t.Setenv("ZIPPY_AUTHOR_EMAIL", "")
+	zippy, err := Init(t.TempDir(), InitOptions{Log: io.Discard})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return zippy
+}
+
+// zipArchive builds a zip archive holding the given entries, in order
+func zipArchive(t *testing.T, entries ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := writer.Create(e[0])
+		if err != nil {
+			t.Fatalf("zip %s: %v", e[0], err)
+		}
+		w.Write([]byte(e[1]))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// storeVersion saves an archive and its metadata straight into storage,
+// the way a remote would hand it over, without any checks
+func storeVersion(t *testing.T, zippy *Repo, tag string, archive []byte) Version {
+	t.Helper()
+	v := Version{Tag: tag, Message: tag, Timestamp: time.Now(), Author: "Test", ZipPath: tag + ".zip", Size: int64(len(archive))}
+	if err := zippy.storage.Put(v.ZipPath, bytes.NewReader(archive)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := zippy.saveVersionInfo(v); err != nil {
+		t.Fatalf("saveVersionInfo: %v", err)
+	}
+	return v
+}
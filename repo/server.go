@@ -0,0 +1,364 @@
+package repo
+
+import (
+	"archive/zip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP API served by 'zippy serve' and used by http:// remotes. See the
+// README for the endpoints.
+const ZIPPY_API = "/api/v1"
+
+// fileEntry describes one file of a version archive
+type fileEntry struct {
+	Name     string    `json:"name"`
+	Size     uint64    `json:"size"`
+	CRC32    uint32    `json:"crc32"`
+	Modified time.Time `json:"modified"`
+}
+
+// versionManifestResponse is a version with the list of its files
+type versionManifestResponse struct {
+	Version
+	Files []fileEntry `json:"files"`
+}
+
+// validTag rejects tags that can't be used as a file name in storage and
+// versions, such as "../x". Tags from remotes and uploads must pass it.
+func validTag(tag string) error {
+	if tag == "" || tag == "." || tag == ".." || strings.ContainsAny(tag, "/\\:\x00") {
+		return fmt.Errorf("invalid version tag %q", tag)
+	}
+	return nil
+}
+
+// publicVersion strips where this repository keeps a version's archive
+func publicVersion(v Version) Version {
+	v.ZipPath = ""
+	v.PackPath = ""
+	v.PackOffset = 0
+	return v
+}
+
+// Serve exposes the repository over HTTP. With a token every request must
+// carry it; without one the server is read-only. An encrypted repository is
+// served decrypted, so it is only served with a token.
+func (zippy *Repo) Serve(addr string, token string) error {
+	if zippy.config.Encryption != nil && token == "" {
+		return invalidError("an encrypted repository is served decrypted, so serving it needs a token (--token or ZIPPY_TOKEN)")
+	}
+	// Unlock before serving, so a passphrase prompt can't block a request
+	if zippy.config.Encryption != nil {
+		if _, err := zippy.unlock(); err != nil {
+			return fmt.Errorf("failed to unlock repository: %w", err)
+		}
+	}
+	local := &localRemote{zippy: zippy}
+	var uploads sync.Mutex
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+ZIPPY_API+"/repo", func(w http.ResponseWriter, r *http.Request) {
+		info := sharedConfig(zippy.config)
+		info.Created = zippy.config.Created
+		info.Encryption = nil
+		writeJSON(w, http.StatusOK, info)
+	})
+	mux.HandleFunc("GET "+ZIPPY_API+"/versions", func(w http.ResponseWriter, r *http.Request) {
+		versions, err := zippy.loadVersions()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].Timestamp.Before(versions[j].Timestamp)
+		})
+		for i := range versions {
+			versions[i] = publicVersion(versions[i])
+		}
+		writeJSON(w, http.StatusOK, versions)
+	})
+	mux.HandleFunc("GET "+ZIPPY_API+"/versions/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		v, ok := zippy.serveVersion(w, r.PathValue("tag"))
+		if !ok {
+			return
+		}
+		zr, err := zippy.openVersionZip(v)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer zr.Close()
+		manifest := versionManifestResponse{Version: publicVersion(v), Files: []fileEntry{}}
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() {
+				manifest.Files = append(manifest.Files, fileEntry{Name: f.Name, Size: f.UncompressedSize64, CRC32: f.CRC32, Modified: f.Modified})
+			}
+		}
+		writeJSON(w, http.StatusOK, manifest)
+	})
+	mux.HandleFunc("GET "+ZIPPY_API+"/versions/{tag}/archive", func(w http.ResponseWriter, r *http.Request) {
+		v, ok := zippy.serveVersion(w, r.PathValue("tag"))
+		if !ok {
+			return
+		}
+		archive, err := local.Fetch(v)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer archive.Close()
+		w.Header().Set("Content-Type", "application/zip")
+		io.Copy(w, archive)
+	})
+	mux.HandleFunc("POST "+ZIPPY_API+"/versions", func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("server is read-only; start it with --token to accept uploads"))
+			return
+		}
+		uploads.Lock()
+		defer uploads.Unlock()
+		v, err := zippy.receiveVersion(r, local)
+		if os.IsExist(err) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		zippy.logf("  Received: %s\n", v.Tag)
+		writeJSON(w, http.StatusCreated, publicVersion(v))
+	})
+
+	handler := http.Handler(mux)
+	if token != "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="zippy"`)
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong token"))
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+	if token == "" {
+		zippy.logf("Serving %s read-only on http://%s%s\n", zippy.config.Name, addr, ZIPPY_API)
+	} else {
+		zippy.logf("Serving %s on http://%s%s\n", zippy.config.Name, addr, ZIPPY_API)
+	}
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 30 * time.Second}
+	return server.ListenAndServe()
+}
+
+// serveVersion loads a version for a request, answering 404 if it doesn't
+// exist
+func (zippy *Repo) serveVersion(w http.ResponseWriter, tag string) (Version, bool) {
+	if err := validTag(tag); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return Version{}, false
+	}
+	v, err := zippy.loadVersion(tag)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, err)
+		return v, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return v, false
+	}
+	return v, true
+}
+
+// receiveVersion reads an upload: a multipart form with the version
+// metadata as JSON in "version", followed by its zip archive in "archive".
+// The archive is checked before anything is stored.
+func (zippy *Repo) receiveVersion(r *http.Request, local *localRemote) (Version, error) {
+	var v Version
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return v, err
+	}
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != "version" {
+		return v, fmt.Errorf("expected the version metadata first")
+	}
+	if err := json.NewDecoder(io.LimitReader(part, 1<<20)).Decode(&v); err != nil {
+		return v, fmt.Errorf("bad version metadata: %v", err)
+	}
+	if err := validTag(v.Tag); err != nil {
+		return v, err
+	}
+	if _, err := zippy.loadVersion(v.Tag); err == nil {
+		return v, fmt.Errorf("version %s already exists: %w", v.Tag, fs.ErrExist)
+	}
+	part, err = mr.NextPart()
+	if err != nil || part.FormName() != "archive" {
+		return v, fmt.Errorf("expected the version archive")
+	}
+	tmp, err := os.CreateTemp("", "zippy_upload_*.zip")
+	if err != nil {
+		return v, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, part)
+	if err != nil {
+		return v, err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return v, fmt.Errorf("archive is not a valid zip: %v", err)
+	}
+	// Every later clone or pull restores this archive, so refuse names
+	// that would write outside the working copy
+	if err := checkArchiveEntries(zr.File); err != nil {
+		return v, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return v, err
+	}
+	if err := local.Store(v, tmp); err != nil {
+		return v, err
+	}
+	v.Size = size
+	return v, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// httpRemote talks to a repository served by 'zippy serve'. The token is
+// read from ZIPPY_TOKEN.
+type httpRemote struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newHTTPRemote(rawURL string) (*httpRemote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote URL %q", rawURL)
+	}
+	base := strings.TrimSuffix(u.String(), "/")
+	if !strings.HasSuffix(base, ZIPPY_API) {
+		base += ZIPPY_API
+	}
+	return &httpRemote{base: base, token: os.Getenv("ZIPPY_TOKEN"), client: &http.Client{}}, nil
+}
+
+// do sends a request and turns error responses into Go errors
+func (r *httpRemote) do(method string, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, r.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		if resp.StatusCode == http.StatusConflict {
+			return nil, fmt.Errorf("%s: %w", apiErr.Error, fs.ErrExist)
+		}
+		return nil, fmt.Errorf("server: %s", apiErr.Error)
+	}
+	return resp, nil
+}
+
+func (r *httpRemote) getJSON(path string, value interface{}) error {
+	resp, err := r.do("GET", path, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+func (r *httpRemote) Config() (RepoConfig, error) {
+	var config RepoConfig
+	err := r.getJSON("/repo", &config)
+	return config, err
+}
+
+func (r *httpRemote) Versions() ([]Version, error) {
+	var versions []Version
+	err := r.getJSON("/versions", &versions)
+	return versions, err
+}
+
+func (r *httpRemote) Fetch(v Version) (io.ReadCloser, error) {
+	resp, err := r.do("GET", "/versions/"+url.PathEscape(v.Tag)+"/archive", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (r *httpRemote) Store(v Version, archive io.Reader) error {
+	// Stream the form, so large archives are never held in memory
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		part, err := form.CreateFormField("version")
+		if err == nil {
+			err = json.NewEncoder(part).Encode(publicVersion(v))
+		}
+		if err == nil {
+			part, err = form.CreateFormFile("archive", v.Tag+".zip")
+		}
+		if err == nil {
+			_, err = io.Copy(part, archive)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := r.do("POST", "/versions", pr, form.FormDataContentType())
+	pr.Close()
+	// Wait for the writer, so the caller can close archive once we return
+	<-done
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		valid bool
+	}{
+		{"v1", true},
+		{"release-1.2.0", true},
+		{"v1.tmp3", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../x", false},
+		{"a/b", false},
+		{"a\\b", false},
+		{"C:x", false},
+		{"v1\x00", false},
+	}
+	for _, tt := range tests {
+		if err := validTag(tt.tag); (err == nil) != tt.valid {
+			t.Errorf("validTag(%q) = %v, want valid %v", tt.tag, err, tt.valid)
+		}
+	}
+}
+
+func TestReceiveVersionChecksEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries [][2]string
+		ok      bool
+	}{
+		{"plain", [][2]string{{"a.txt", "a"}}, true},
+		{"parent", [][2]string{{"../evil", "x"}}, false},
+		{"backslash parent", [][2]string{{"..\\evil", "x"}}, false},
+		{"absolute", [][2]string{{"/etc/evil", "x"}}, false},
+		{"duplicate", [][2]string{{"a.txt", "a"}, {"a.txt", "b"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zippy := newTestRepo(t)
+			v := Version{Tag: "v1", Message: "m", Timestamp: time.Now(), Author: "Test"}
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, _ := form.CreateFormField("version")
+			json.NewEncoder(part).Encode(publicVersion(v))
+			part, _ = form.CreateFormFile("archive", "v1.zip")
+			part.Write(zipArchive(t, tt.entries...))
+			form.Close()
+			r := httptest.NewRequest("POST", ZIPPY_API+"/versions", &body)
+			r.Header.Set("Content-Type", form.FormDataContentType())
+
+			_, err := zippy.receiveVersion(r, &localRemote{zippy: zippy})
+			if tt.ok != (err == nil) {
+				t.Fatalf("receiveVersion error = %v, want ok=%v", err, tt.ok)
+			}
+			if _, err := zippy.loadVersion("v1"); tt.ok != (err == nil) {
+				t.Errorf("version stored = %v, want %v", err == nil, tt.ok)
+			}
+		})
+	}
+}
+
+func TestServeEncryptedNeedsToken(t *testing.T) {
+	zippy := newTestRepo(t)
+	zippy.config.Encryption = &EncryptionConfig{}
+	if err := zippy.Serve("localhost:0", ""); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Serve without a token = %v, want ErrInvalid", err)
+	}
+}
@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VersionDetails is a version with the contents of its archive and the
+// changes from the version before it
+type VersionDetails struct {
+	Version
+	Parent           string      `json:"parent,omitempty"` // the previous version, if any
+	UncompressedSize int64       `json:"uncompressed_size"`
+	Files            []FileEntry `json:"files"`
+	Changes          *Changes    `json:"changes,omitempty"` // from the parent
+}
+
+// FileEntry is a file in a version archive
+type FileEntry struct {
+	Path           string      `json:"path"`
+	Size           int64       `json:"size"`
+	CompressedSize int64       `json:"compressed_size"`
+	Mode           fs.FileMode `json:"mode"`
+	Modified       time.Time   `json:"modified"`
+	CRC32          uint32      `json:"crc32"`
+}
+
+// Show reads a version with its files and compares it with its parent, the
+// version created just before it
+func (zippy *Repo) Show(tag string) (*VersionDetails, error) {
+	v, err := zippy.loadVersion(tag)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := zippy.versionEntries(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", tag, err)
+	}
+	details := &VersionDetails{Version: v, Files: entries}
+	files := map[string]uint32{}
+	for _, f := range entries {
+		details.UncompressedSize += f.Size
+		files[f.Path] = f.CRC32
+	}
+
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	sortVersions(versions)
+	for i, other := range versions {
+		if other.Tag != tag {
+			continue
+		}
+		before := map[string]uint32{}
+		if i > 0 {
+			details.Parent = versions[i-1].Tag
+			if before, err = zippy.versionFiles(versions[i-1]); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", details.Parent, err)
+			}
+		}
+		changes := compareFiles(before, files)
+		details.Changes = &changes
+	}
+	return details, nil
+}
+
+// versionEntries lists the files of a version archive by path
+func (zippy *Repo) versionEntries(v Version) ([]FileEntry, error) {
+	zr, err := zippy.openVersionZip(v)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	entries := []FileEntry{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		entries = append(entries, FileEntry{
+			Path:           f.Name,
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			Mode:           f.Mode(),
+			Modified:       f.Modified,
+			CRC32:          f.CRC32,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// OpenFile opens a file of a version archive for reading. Closing it also
+// closes the archive.
+func (zippy *Repo) OpenFile(tag string, name string) (io.ReadCloser, error) {
+	v, err := zippy.loadVersion(tag)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zippy.openVersionZip(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", tag, err)
+	}
+	name = strings.Trim(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/")
+	for _, f := range zr.File {
+		if f.Name != name && f.Name != name+"/" {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			break
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("failed to read %s from %s: %w", name, tag, err)
+		}
+		return &entryReader{ReadCloser: rc, archive: zr}, nil
+	}
+	isDir := slices.ContainsFunc(zr.File, func(f *zip.File) bool { return strings.HasPrefix(f.Name, name+"/") })
+	zr.Close()
+	if isDir {
+		return nil, invalidError("%s is a folder in version %s", name, tag)
+	}
+	return nil, notFoundError("%s not found in version %s", name, tag)
+}
+
+// entryReader is an open archive entry that closes its archive with it
+type entryReader struct {
+	io.ReadCloser
+	archive *archiveReader
+}
+
+func (r *entryReader) Close() error {
+	err := r.ReadCloser.Close()
+	if cerr := r.archive.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
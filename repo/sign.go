@@ -0,0 +1,268 @@
+package repo
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SigningKeyPath returns the Ed25519 key used by 'zippy commit --sign'.
+// ZIPPY_SIGNING_KEY overrides the default in the user config directory.
+func SigningKeyPath() (string, error) {
+	if path := os.Getenv("ZIPPY_SIGNING_KEY"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "zippy", "signing.key"), nil
+}
+
+// GenerateSigningKey writes a new Ed25519 private key to path as PKCS#8 PEM
+func GenerateSigningKey(path string) (ed25519.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return public, file.Close()
+}
+
+// LoadSigningKey reads a private key written by GenerateSigningKey
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// parsePublicKey decodes a public key as printed by 'zippy key public'
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "ed25519:"))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %q", s)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// FormatPublicKey encodes a public key as ed25519:<base64>
+func FormatPublicKey(key ed25519.PublicKey) string {
+	return "ed25519:" + base64.StdEncoding.EncodeToString(key)
+}
+
+// versionManifest returns the bytes a version signature covers: the version
+// metadata and the SHA-256 of every file in its archive. The archive layout
+// and compression are left out, so gc can repack and recompress signed
+// versions.
+func versionManifest(v Version, zr *zip.Reader) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("zippy manifest 1\n")
+	fmt.Fprintf(&b, "tag %q\n", v.Tag)
+	fmt.Fprintf(&b, "message %q\n", v.Message)
+	fmt.Fprintf(&b, "author %q\n", v.Author)
+	if v.AuthorEmail != "" {
+		// Left out when empty, so versions signed before emails were
+		// recorded still verify
+		fmt.Fprintf(&b, "author_email %q\n", v.AuthorEmail)
+	}
+	fmt.Fprintf(&b, "timestamp %s\n", v.Timestamp.UTC().Format(time.RFC3339Nano))
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	for _, f := range files {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		fmt.Fprintf(&b, "file %x %d %q\n", h.Sum(nil), f.UncompressedSize64, f.Name)
+	}
+	return b.Bytes(), nil
+}
+
+// signVersion signs the manifest of v, whose files are in the archive at
+// zipPath
+func signVersion(v *Version, zipPath string, key ed25519.PrivateKey) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	manifest, err := versionManifest(*v, &zr.Reader)
+	if err != nil {
+		return err
+	}
+	v.SignedBy = FormatPublicKey(key.Public().(ed25519.PublicKey))
+	v.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest))
+	return nil
+}
+
+// trustedKeyName returns the name a public key was trusted under
+func (zippy *Repo) trustedKeyName(publicKey string) (string, bool) {
+	for _, k := range zippy.config.TrustedKeys {
+		if k.PublicKey == publicKey {
+			return k.Name, true
+		}
+	}
+	return "", false
+}
+
+// TrustKey adds a public key to the trusted keys in config.json, or renames
+// it if it is already there
+func (zippy *Repo) TrustKey(publicKey string, name string) error {
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return invalidError("%v", err)
+	}
+	publicKey = FormatPublicKey(key)
+	if name == "" {
+		name = publicKey[len("ed25519:") : len("ed25519:")+8]
+	}
+	found := false
+	for i, k := range zippy.config.TrustedKeys {
+		if k.PublicKey == publicKey {
+			zippy.config.TrustedKeys[i].Name = name
+			found = true
+		}
+	}
+	if !found {
+		zippy.config.TrustedKeys = append(zippy.config.TrustedKeys, TrustedKey{Name: name, PublicKey: publicKey})
+	}
+	if err := zippy.saveConfig(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	zippy.logf("Trusted key %s as %s\n", publicKey, name)
+	return nil
+}
+
+// Verify reads every file of the given versions (all when none are given)
+// to check the archives are intact and, with signatures, that each version
+// is signed by a trusted key
+func (zippy *Repo) Verify(tags []string, signatures bool) error {
+	var versions []Version
+	if len(tags) == 0 {
+		all, err := zippy.loadVersions()
+		if err != nil {
+			return err
+		}
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].Timestamp.Before(all[j].Timestamp)
+		})
+		versions = all
+	} else {
+		for _, tag := range tags {
+			v, err := zippy.loadVersion(tag)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, v)
+		}
+	}
+	failed := 0
+	for _, v := range versions {
+		signer, err := zippy.verifyVersion(v, signatures)
+		switch {
+		case err != nil:
+			failed++
+			zippy.logf("  FAILED %s: %v\n", v.Tag, err)
+		case signer != "":
+			zippy.logf("  OK     %s (signed by %s)\n", v.Tag, signer)
+		default:
+			zippy.logf("  OK     %s\n", v.Tag)
+		}
+	}
+	zippy.logf("Verified %d versions, %d failed.\n", len(versions), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d versions failed verification", failed, len(versions))
+	}
+	return nil
+}
+
+// verifyVersion checks a single version and returns the name of the
+// trusted key that signed it, if signatures are checked
+func (zippy *Repo) verifyVersion(v Version, signatures bool) (string, error) {
+	zr, err := zippy.openVersionZip(v)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	// Building the manifest reads every entry, and archive/zip checks the
+	// CRC32 of each one as it is read
+	manifest, err := versionManifest(v, zr.Reader)
+	if err != nil {
+		return "", err
+	}
+	if !signatures {
+		return "", nil
+	}
+	if v.Signature == "" {
+		return "", fmt.Errorf("not signed")
+	}
+	name, ok := zippy.trustedKeyName(v.SignedBy)
+	if !ok {
+		return "", fmt.Errorf("signed by untrusted key %s", v.SignedBy)
+	}
+	key, err := parsePublicKey(v.SignedBy)
+	if err != nil {
+		return "", err
+	}
+	signature, err := base64.StdEncoding.DecodeString(v.Signature)
+	if err != nil || !ed25519.Verify(key, manifest, signature) {
+		return "", fmt.Errorf("bad signature from %s", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,450 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storageRel returns the storage object name for an archive path from
+// version metadata. New metadata already holds the relative name; absolute
+// paths written before format 1, possibly on another machine or OS, are
+// mapped back onto the storage directory.
+func (zippy *Repo) storageRel(path string) string {
+	slashed := strings.ReplaceAll(path, "\\", "/")
+	absolute := filepath.IsAbs(path) || strings.HasPrefix(slashed, "/") || (len(slashed) > 1 && slashed[1] == ':')
+	if !absolute {
+		return slashed
+	}
+	if rel, err := filepath.Rel(zippy.storagePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	if i := strings.LastIndex(slashed, "/.zippy/storage/"); i >= 0 {
+		return slashed[i+len("/.zippy/storage/"):]
+	}
+	return slashed[strings.LastIndex(slashed, "/")+1:]
+}
+
+// archiveName returns the storage object that holds a version's archive
+func (zippy *Repo) archiveName(v Version) string {
+	if v.PackPath != "" {
+		return zippy.storageRel(v.PackPath)
+	}
+	return zippy.storageRel(v.ZipPath)
+}
+
+// putFile uploads a local file to storage under the given name
+func (zippy *Repo) putFile(name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return zippy.storage.Put(name, file)
+}
+
+// StorageObject describes a file kept by a storage backend
+type StorageObject struct {
+	Name    string // slash-separated path relative to the storage root
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is where version archives and pack files are kept. Names are
+// slash-separated paths relative to the storage root, e.g. "v1.0.zip" or
+// "packs/pack-1.pack".
+type Storage interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	List() ([]StorageObject, error)
+	Delete(name string) error
+	Stat(name string) (StorageObject, error)
+}
+
+// openStorage returns the backend selected in the repository config
+func (zippy *Repo) openStorage() (Storage, error) {
+	cfg := zippy.config.Storage
+	if cfg == nil || cfg.Type == "" || cfg.Type == STORAGE_LOCAL {
+		return &localStorage{root: zippy.storagePath}, nil
+	}
+	if cfg.Type == STORAGE_S3 {
+		return newS3Storage(cfg)
+	}
+	return nil, fmt.Errorf("unknown storage type %q (use local or s3)", cfg.Type)
+}
+
+// localStorage keeps archives in a directory on disk
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+// TEMP_OBJECT_SUFFIX marks the files writeFileAtomic writes before renaming
+// them into place
+const TEMP_OBJECT_SUFFIX = ".tmp"
+
+// isArchiveObject reports whether a storage name is one Zippy gives
+// archives: "<tag>.zip" at the top or "packs/pack-<n>.pack"
+func isArchiveObject(name string) bool {
+	if tag, ok := strings.CutSuffix(name, ".zip"); ok {
+		return validTag(tag) == nil
+	}
+	if rest, ok := strings.CutPrefix(name, ZIPPY_PACKS+"/pack-"); ok {
+		n, ok := strings.CutSuffix(rest, ".pack")
+		return ok && isDigits(n)
+	}
+	return false
+}
+
+// isTempObject reports whether a storage name is an archive being written:
+// the archive name, TEMP_OBJECT_SUFFIX and the random digits os.CreateTemp
+// appends
+func isTempObject(name string) bool {
+	i := strings.LastIndex(name, TEMP_OBJECT_SUFFIX)
+	return i > 0 && isDigits(name[i+len(TEMP_OBJECT_SUFFIX):]) && isArchiveObject(name[:i])
+}
+
+// isDigits reports whether s is a non-empty run of decimal digits
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+func (s *localStorage) Put(name string, r io.Reader) error {
+	path := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, r)
+}
+
+func (s *localStorage) Get(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+func (s *localStorage) List() ([]StorageObject, error) {
+	objects := []StorageObject{}
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(s.root, path)
+		objects = append(objects, StorageObject{Name: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return objects, nil
+	}
+	return objects, err
+}
+
+func (s *localStorage) Delete(name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s *localStorage) Stat(name string) (StorageObject, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return StorageObject{}, err
+	}
+	return StorageObject{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// s3Storage keeps archives in a bucket of an S3-compatible object store such
+// as AWS S3 or MinIO. Requests use path-style URLs and are signed with AWS
+// Signature Version 4.
+type s3Storage struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newS3Storage(cfg *StorageConfig) (*s3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs an endpoint and a bucket in .zippy/config.json")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	accessKey := firstEnv("ZIPPY_S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID")
+	secretKey := firstEnv("ZIPPY_S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("s3 credentials missing: set ZIPPY_S3_ACCESS_KEY and ZIPPY_S3_SECRET_KEY")
+	}
+	return &s3Storage{
+		endpoint:     endpoint,
+		bucket:       cfg.Bucket,
+		prefix:       strings.Trim(cfg.Prefix, "/"),
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{},
+	}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Storage) Put(name string, r io.Reader) error {
+	// Signing needs the payload hash and S3 needs the length up front, so
+	// the body must be seekable. Spool anything else to a temp file.
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := os.CreateTemp("", "zippy_s3_*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return err
+		}
+		body = tmp
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPut, s.key(name), nil, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Get(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.key(name), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) List() ([]StorageObject, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	objects := []StorageObject{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid list response: %v", err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, StorageObject{Name: strings.TrimPrefix(c.Key, prefix), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Storage) Delete(name string) error {
+	resp, err := s.do(http.MethodDelete, s.key(name), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Stat(name string) (StorageObject, error) {
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, 0)
+	if err != nil {
+		return StorageObject{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return StorageObject{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// do signs and sends a request for an object key, or for the bucket itself
+// when key is empty. Missing objects are reported as fs.ErrNotExist.
+func (s *s3Storage) do(method, key string, query url.Values, body io.ReadSeeker, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	payloadHash := sha256Hex(nil)
+	var reqBody io.Reader
+	if body != nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+		reqBody = io.NopCloser(body)
+	}
+	req, err := http.NewRequest(method, u.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: %s: %w", key, fs.ErrNotExist)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("s3: unexpected response %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *s3Storage) sign(req *http.Request, payloadHash string) {
+	s.signAt(req, payloadHash, time.Now())
+}
+
+// signAt signs req as of the time now
+func (s *s3Storage) signAt(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		signed = append(signed, strings.ToLower(name))
+	}
+	sort.Strings(signed)
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape percent-encodes everything except unreserved characters, as
+// required by Signature Version 4. Slashes are kept unless encodeSlash.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by key
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,142 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ignoreTemplates are the built-in patterns 'zippy init --template' adds to
+// the default .zippyignore
+var ignoreTemplates = map[string]string{
+	"go": `# Build output and test artifacts
+bin/
+vendor/
+*.test
+*.out
+coverage.txt
+`,
+	"java": `# Build output
+target/
+build/
+out/
+.gradle/
+*.class
+*.jar
+*.war
+.idea/
+`,
+	"node": `# Dependencies and build output
+node_modules/
+dist/
+build/
+coverage/
+.next/
+.nuxt/
+.cache/
+npm-debug.log*
+yarn-error.log*
+`,
+	"python": `# Bytecode, virtual environments and build output
+__pycache__/
+*.pyc
+*.pyo
+.venv/
+venv/
+env/
+build/
+dist/
+*.egg-info/
+.pytest_cache/
+.mypy_cache/
+.ipynb_checkpoints/
+`,
+	"rust": `# Build output
+target/
+`,
+	"unity": `# Generated folders and IDE files
+Library/
+Temp/
+Obj/
+Build/
+Builds/
+Logs/
+UserSettings/
+MemoryCaptures/
+*.csproj
+*.sln
+*.suo
+*.user
+*.pidb
+*.booproj
+.vs/
+`,
+}
+
+// TemplatesDir returns the folder of user-defined .zippyignore templates.
+// A file <name>.zippyignore there is used by 'zippy init --template <name>'
+// and takes the place of a built-in template of the same name.
+func TemplatesDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "zippy", "templates"), nil
+}
+
+// IgnoreTemplates returns the names of the built-in and user-defined
+// templates
+func IgnoreTemplates() []string {
+	names := []string{}
+	for name := range ignoreTemplates {
+		names = append(names, name)
+	}
+	if dir, err := TemplatesDir(); err == nil {
+		files, _ := os.ReadDir(dir)
+		for _, file := range files {
+			if name, ok := strings.CutSuffix(file.Name(), ".zippyignore"); ok && !file.IsDir() && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// IgnoreTemplate returns the patterns of a template, preferring a user
+// template over the built-in one
+func IgnoreTemplate(name string) (string, error) {
+	if dir, err := TemplatesDir(); err == nil && !strings.ContainsAny(name, `/\`) {
+		data, err := os.ReadFile(filepath.Join(dir, name+".zippyignore"))
+		if err == nil {
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read template %s: %w", name, err)
+		}
+	}
+	if patterns, ok := ignoreTemplates[name]; ok {
+		return patterns, nil
+	}
+	return "", invalidError("unknown template %q (use one of %s)", name, strings.Join(IgnoreTemplates(), ", "))
+}
+
+// zippyIgnoreFor returns the .zippyignore of a new repository: the default
+// patterns followed by those of each template
+func zippyIgnoreFor(templates []string) (string, error) {
+	var b strings.Builder
+	b.WriteString(defaultZippyIgnore)
+	for _, name := range templates {
+		patterns, err := IgnoreTemplate(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\n# Template: %s\n%s", name, patterns)
+		if !strings.HasSuffix(patterns, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
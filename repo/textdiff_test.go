@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// checkScript fails unless ops turns a into b with the given number of
+// removed and added lines
+func checkScript(t *testing.T, name string, a []string, b []string, ops []diffOp, edits int) {
+	t.Helper()
+	from, to := []string{}, []string{}
+	changed := 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			from = append(from, op.line)
+		}
+		if op.kind != '-' {
+			to = append(to, op.line)
+		}
+		if op.kind != ' ' {
+			changed++
+		}
+	}
+	if !slices.Equal(from, a) || !slices.Equal(to, b) {
+		t.Errorf("%s: edit script does not turn a into b", name)
+	}
+	if changed != edits {
+		t.Errorf("%s: %d edits, want %d", name, changed, edits)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		edits int
+	}{
+		{"equal", "a b c", "a b c", 0},
+		{"both empty", "", "", 0},
+		{"from empty", "", "a b", 2},
+		{"to empty", "a b", "", 2},
+		{"insert first", "b c", "a b c", 1},
+		{"remove last", "a b c", "a b", 1},
+		{"replace middle", "a b c", "a x c", 2},
+		{"move", "a b c d", "b c d a", 2},
+		{"interleaved", "a b c a b b a", "c b a b a c", 5},
+	}
+	for _, tt := range tests {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		checkScript(t, tt.name, a, b, diffLines(a, b), tt.edits)
+	}
+}
+
+func TestDiffLinesEditCap(t *testing.T) {
+	lines := func(n int, prefix func(int) string) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = prefix(i)
+		}
+		return out
+	}
+	base := lines(3000, func(i int) string { return fmt.Sprint("line ", i) })
+	// Every third line changes: 1000 removed and 1000 added, right at the cap
+	atCap := lines(3000, func(i int) string {
+		if i%3 == 0 {
+			return fmt.Sprint("changed ", i)
+		}
+		return base[i]
+	})
+	rewritten := lines(3000, func(i int) string { return fmt.Sprint("new ", i) })
+	overCap := lines(3000, func(i int) string {
+		if i%3 != 2 {
+			return fmt.Sprint("changed ", i)
+		}
+		return base[i]
+	})
+
+	checkScript(t, "at the cap", base, atCap, diffLines(base, atCap), 2000)
+	checkScript(t, "rewritten", base, rewritten, diffLines(base, rewritten), 6000)
+	// Over the cap the script gives up on the kept lines and replaces all
+	checkScript(t, "over the cap", base, overCap, diffLines(base, overCap), 6000)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	numbered := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"line endings only", "a\r\nb\r\n", "a\nb\n", "Files a/f and b/f differ only in line endings\n"},
+		{"binary", "a\x00", "b", "Binary files a/f and b/f differ\n"},
+		{"new file", "", "a\nb\n", "--- a/f\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"deleted file", "a\n", "", "--- a/f\n+++ b/f\n@@ -1,1 +0,0 @@\n-a\n"},
+		{
+			"one change with context", numbered(10), strings.Replace(numbered(10), "5\n", "five\n", 1),
+			"--- a/f\n+++ b/f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"two hunks", numbered(20), strings.Replace(strings.Replace(numbered(20), "\n2\n", "\nB\n", 1), "\n19\n", "\nS\n", 1),
+			"--- a/f\n+++ b/f\n@@ -1,5 +1,5 @@\n 1\n-2\n+B\n 3\n 4\n 5\n@@ -16,5 +16,5 @@\n 16\n 17\n 18\n-19\n+S\n 20\n",
+		},
+		{
+			"close changes share a hunk", numbered(12), strings.Replace(strings.Replace(numbered(12), "\n3\n", "\nC\n", 1), "\n9\n", "\nI\n", 1),
+			"--- a/f\n+++ b/f\n@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+C\n 4\n 5\n 6\n 7\n 8\n-9\n+I\n 10\n 11\n 12\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := UnifiedDiff("a/f", "b/f", []byte(tt.a), []byte(tt.b)); got != tt.want {
+			t.Errorf("%s: UnifiedDiff =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
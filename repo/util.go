@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Helper functions for copying files and directories
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// Helper to get CRC32 of a file
+func fileCRC32(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// FormatSize formats a byte count for display, such as 1.5 MB
+func FormatSize(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f %cB", sign, float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Helper to read the first non-empty environment variable
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Helpers for hashing and HMAC with SHA-256
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Helper that counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Helper to write a file through a temp file in the same directory, so a
+// failed write never leaves a partial file behind and readers never see half
+// a file
+func writeFileAtomic(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+TEMP_OBJECT_SUFFIX+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// isLocalEntry reports whether an archive entry name stays inside the
+// folder it is extracted to on any platform: not absolute, no drive letter
+// and no ".." element, with either kind of slash
+func isLocalEntry(name string) bool {
+	slashed := strings.TrimSuffix(strings.ReplaceAll(name, "\\", "/"), "/")
+	if slashed == "" || path.IsAbs(slashed) || (len(slashed) >= 2 && slashed[1] == ':') {
+		return false
+	}
+	if slices.Contains(strings.Split(slashed, "/"), "..") {
+		return false
+	}
+	return filepath.IsLocal(filepath.FromSlash(slashed))
+}
+
+// checkArchiveEntries rejects an archive from elsewhere before it is stored:
+// every entry must be a regular file or folder with a local name, and no
+// name may appear twice
+func checkArchiveEntries(files []*zip.File) error {
+	seen := map[string]bool{}
+	for _, f := range files {
+		if !isLocalEntry(f.Name) {
+			return invalidError("archive entry %q is outside the working copy", f.Name)
+		}
+		if mode := f.Mode(); !mode.IsRegular() && !mode.IsDir() {
+			return invalidError("archive entry %q is not a regular file", f.Name)
+		}
+		name := strings.TrimSuffix(strings.ReplaceAll(f.Name, "\\", "/"), "/")
+		if seen[name] {
+			return invalidError("archive entry %q appears more than once", f.Name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,886 @@
+package repo
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// StageResult lists what Stage did with the given paths
+type StageResult struct {
+	Added   []string `json:"added"`
+	Ignored []string `json:"ignored"` // paths given by name that .zippyignore excludes
+	Missing []string `json:"missing"` // paths that don't exist or can't be read
+}
+
+// Stage records files or folders for the next commit in .zippy/stage.json.
+// Paths are relative to the working copy; "." stages everything that
+// .zippyignore doesn't exclude. Missing paths are reported with an
+// ErrNotFound error after the others are staged.
+func (zippy *Repo) Stage(paths []string) (*StageResult, error) {
+	zippyignore := zippy.loadZippyIgnore()
+	result := &StageResult{Added: []string{}, Ignored: []string{}, Missing: []string{}}
+	staged := map[string]struct{}{}
+	add := func(relPath string) {
+		if _, ok := staged[relPath]; !ok {
+			staged[relPath] = struct{}{}
+			result.Added = append(result.Added, relPath)
+		}
+	}
+	if len(paths) == 1 && paths[0] == "." {
+		filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, _ := filepath.Rel(zippy.repoPath, path)
+			if relPath == "." {
+				return nil
+			}
+			if zippyignore.shouldIgnore(relPath) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				add(relPath)
+			}
+			return nil
+		})
+	} else {
+		for _, p := range paths {
+			absPath := filepath.Join(zippy.repoPath, p)
+			info, err := os.Stat(absPath)
+			if err != nil {
+				result.Missing = append(result.Missing, p)
+				continue
+			}
+			if zippyignore.shouldIgnore(p) {
+				result.Ignored = append(result.Ignored, p)
+				continue
+			}
+			if info.IsDir() {
+				filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					relPath, _ := filepath.Rel(zippy.repoPath, path)
+					if relPath == "." {
+						return nil
+					}
+					if zippyignore.shouldIgnore(relPath) {
+						if info.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+					if !info.IsDir() {
+						add(relPath)
+					}
+					return nil
+				})
+			} else {
+				add(p)
+			}
+		}
+	}
+	if len(staged) > 0 {
+		// Save staged files to .zippy/stage.json
+		stageList := make([]string, 0, len(staged))
+		for f := range staged {
+			stageList = append(stageList, f)
+		}
+		sort.Strings(stageList)
+		data, _ := json.MarshalIndent(stageList, "", "  ")
+		if err := os.WriteFile(zippy.stagePath, data, 0644); err != nil {
+			return result, fmt.Errorf("failed to save staging area: %w", err)
+		}
+	}
+	if len(result.Missing) > 0 {
+		return result, notFoundError("not found or inaccessible: %s", strings.Join(result.Missing, ", "))
+	}
+	return result, nil
+}
+
+// CommitOptions describe a new version. Empty fields get defaults.
+type CommitOptions struct {
+	Message string // default: No message
+	Tag     string // default: v<unix time>
+	Author  string // default: Identity()
+	Email   string // of Author
+	Method  string // compression method instead of the configured one
+	Level   int    // compression level instead of the configured one
+	Sign    bool   // sign the version with an Ed25519 key
+	KeyPath string // signing key; default: SigningKeyPath()
+}
+
+// Commit creates a version from the staged files and clears the staging
+// area
+func (zippy *Repo) Commit(opts CommitOptions) (*Version, error) {
+	message, version := opts.Message, opts.Tag
+	if message == "" {
+		message = "No message"
+	}
+	if version == "" {
+		version = fmt.Sprintf("v%d", time.Now().Unix())
+	}
+	if err := validTag(version); err != nil {
+		return nil, invalidError("%v", err)
+	}
+	if _, err := zippy.loadVersion(version); err == nil {
+		return nil, conflictError("version %s already exists", version)
+	}
+	method, level := zippy.compressionMethod(), zippy.compressionLevel()
+	if opts.Method != "" {
+		method = opts.Method
+	}
+	if opts.Level != 0 {
+		level = opts.Level
+	}
+	if err := validateCompression(method, level); err != nil {
+		return nil, invalidError("%v", err)
+	}
+	author, email := opts.Author, opts.Email
+	if author == "" {
+		author, email = zippy.Identity()
+	}
+	// Load the signing key up front, so a missing key doesn't leave an
+	// unsigned version behind
+	var signingKey ed25519.PrivateKey
+	if opts.Sign {
+		var err error
+		keyPath := opts.KeyPath
+		if keyPath == "" {
+			keyPath, err = SigningKeyPath()
+		}
+		if err == nil {
+			signingKey, err = LoadSigningKey(keyPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w. Create a signing key with 'zippy key generate'", err)
+		}
+	}
+	zippy.logf("Creating version %s: %s\n", version, message)
+	// Read staged files
+	stageList := []string{}
+	if data, err := os.ReadFile(zippy.stagePath); err == nil {
+		json.Unmarshal(data, &stageList)
+	}
+	if len(stageList) == 0 {
+		return nil, errors.New("no files staged. Use 'zippy add <files>' to stage files")
+	}
+	// Create zip file in a temp dir, then hand it to storage
+	tempDir, err := os.MkdirTemp("", "zippy_commit_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	name := version + ".zip"
+	tempZip := filepath.Join(tempDir, name)
+	if err := zippy.createZipFileWithList(tempZip, stageList, method, level); err != nil {
+		return nil, fmt.Errorf("failed to create zip: %w", err)
+	}
+	// Build and sign the metadata before uploading, so a failure leaves
+	// nothing in storage
+	versionInfo := Version{
+		Tag:         version,
+		Message:     message,
+		Timestamp:   time.Now(),
+		Author:      author,
+		AuthorEmail: email,
+		ZipPath:     name,
+		FilesCount:  len(stageList),
+	}
+	// Get file info
+	if stat, err := os.Stat(tempZip); err == nil {
+		versionInfo.Size = stat.Size()
+	}
+	if signingKey != nil {
+		if err := signVersion(&versionInfo, tempZip, signingKey); err != nil {
+			return nil, fmt.Errorf("failed to sign version: %w", err)
+		}
+	}
+	if err := zippy.putFile(name, tempZip); err != nil {
+		return nil, fmt.Errorf("failed to store zip: %w", err)
+	}
+	if err := zippy.saveVersionInfo(versionInfo); err != nil {
+		zippy.storage.Delete(name)
+		return nil, fmt.Errorf("failed to save version metadata: %w", err)
+	}
+	// Clear staging area
+	os.Remove(zippy.stagePath)
+	return &versionInfo, nil
+}
+
+func (zippy *Repo) createZipFile(zipPath string) error {
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+
+	writer := zip.NewWriter(zipFile)
+	defer writer.Close()
+
+	zippyignore := zippy.loadZippyIgnore()
+	fileCount := 0
+
+	err = filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, _ := filepath.Rel(zippy.repoPath, path)
+
+		// Skip if should be ignored
+		if zippyignore.shouldIgnore(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		// Add file to zip
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		f, err := writer.Create(relPath)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, file)
+		if err != nil {
+			return err
+		}
+
+		fileCount++
+		return nil
+	})
+
+	zippy.logf("Packed %d files into %s\n", fileCount, filepath.Base(zipPath))
+	return err
+}
+
+// createZipFileWithList writes the staged files, and the contents of staged
+// folders, to a new archive. A file that can't be read fails the archive.
+func (zippy *Repo) createZipFileWithList(zipPath string, files []string, method string, level int) error {
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+	writer := zippy.newZipWriter(zipFile, level)
+	add := func(path string, rel string, info os.FileInfo) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		f, err := writer.CreateHeader(entryHeader(rel, info, method))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, file)
+		return err
+	}
+	for _, relPath := range files {
+		absPath := filepath.Join(zippy.repoPath, relPath)
+		info, err := os.Stat(absPath)
+		if err == nil && info.IsDir() {
+			// Add all files in the directory
+			err = filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				rel, _ := filepath.Rel(zippy.repoPath, path)
+				return add(path, rel, info)
+			})
+		} else if err == nil {
+			err = add(absPath, relPath, info)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", relPath, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	zippy.logf("Packed %d files into %s\n", len(files), filepath.Base(zipPath))
+	return zipFile.Close()
+}
+
+// Tags returns the tags of all versions in the order of their metadata
+// files
+func (zippy *Repo) Tags() ([]string, error) {
+	files, err := os.ReadDir(zippy.versionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions: %w", err)
+	}
+	tags := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			tags = append(tags, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return tags, nil
+}
+
+// Version returns the metadata of a version
+func (zippy *Repo) Version(tag string) (*Version, error) {
+	v, err := zippy.loadVersion(tag)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Versions returns the metadata of every version, failing on the first
+// unreadable one
+func (zippy *Repo) Versions() ([]Version, error) {
+	return zippy.loadVersions()
+}
+
+// RestoreOptions select what Restore writes to the working copy
+type RestoreOptions struct {
+	Tag  string
+	Path string // a file or folder of the version; empty for all files
+}
+
+// RestoreResult is the outcome of restoring one file
+type RestoreResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"` // restored or failed
+	Error  string `json:"error,omitempty"`
+}
+
+// Restore writes the files of a version to the working copy. It returns a
+// result per file, and an error as well if any of them failed or nothing
+// matched the path.
+func (zippy *Repo) Restore(opts RestoreOptions) ([]RestoreResult, error) {
+	// Load version metadata
+	v, err := zippy.loadVersion(opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+	zipReader, err := zippy.openVersionZip(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+	defer zipReader.Close()
+	// Normalize restorePath for matching
+	restorePath := filepath.ToSlash(opts.Path)
+	results := []RestoreResult{}
+	failed, lastErr := 0, error(nil)
+	for _, f := range zipReader.File {
+		// An entry like ../x from an untrusted remote must not escape
+		if !isLocalEntry(f.Name) {
+			err := invalidError("unsafe path %q", f.Name)
+			results = append(results, RestoreResult{Path: f.Name, Status: "failed", Error: err.Error()})
+			failed, lastErr = failed+1, err
+			continue
+		}
+		filePath := filepath.Join(zippy.repoPath, f.Name)
+		zipEntryPath := filepath.ToSlash(f.Name)
+		// If restorePath is set, only restore matching file or folder
+		if restorePath != "" {
+			if zipEntryPath != restorePath && !strings.HasPrefix(zipEntryPath, restorePath+"/") {
+				continue
+			}
+		}
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(filePath, 0755)
+			continue
+		}
+		if err := zippy.restoreFile(f, filePath); err != nil {
+			results = append(results, RestoreResult{Path: f.Name, Status: "failed", Error: err.Error()})
+			failed, lastErr = failed+1, err
+			continue
+		}
+		results = append(results, RestoreResult{Path: f.Name, Status: "restored"})
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d files could not be restored: %w", failed, lastErr)
+	}
+	if len(results) == 0 && restorePath != "" {
+		return results, notFoundError("'%s' not found in version %s", opts.Path, opts.Tag)
+	}
+	return results, nil
+}
+
+// restoreFile writes one archive entry to path
+func (zippy *Repo) restoreFile(f *zip.File, path string) error {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	rc, err := f.Open()
+	if err == zip.ErrAlgorithm {
+		return fmt.Errorf("unsupported compression method %d", f.Method)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(outFile, rc); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+// Changes lists the files that differ between two sets of files
+type Changes struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// compareFiles compares files by name and CRC-32
+func compareFiles(before map[string]uint32, after map[string]uint32) Changes {
+	changes := Changes{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for name, crc := range after {
+		if old, ok := before[name]; !ok {
+			changes.Added = append(changes.Added, name)
+		} else if old != crc {
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+	return changes
+}
+
+// Empty reports whether nothing changed
+func (changes Changes) Empty() bool {
+	return len(changes.Added) == 0 && len(changes.Removed) == 0 && len(changes.Changed) == 0
+}
+
+// versionFiles maps the files of a version to their CRC-32
+func (zippy *Repo) versionFiles(v Version) (map[string]uint32, error) {
+	zr, err := zippy.openVersionZip(v)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := map[string]uint32{}
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files[f.Name] = f.CRC32
+		}
+	}
+	return files, nil
+}
+
+// Status describes the working copy. The changes are against the latest
+// version and only present when there is one.
+type Status struct {
+	Files   []string `json:"files"` // files 'zippy add .' would stage
+	Ignored []string `json:"ignored"`
+	Latest  string   `json:"latest,omitempty"`
+	*Changes
+}
+
+// Status walks the working copy and compares it with the latest version
+func (zippy *Repo) Status() (*Status, error) {
+	zippyignore := zippy.loadZippyIgnore()
+	result := &Status{Files: []string{}, Ignored: []string{}}
+	current := map[string]uint32{}
+	err := filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(zippy.repoPath, path)
+		if relPath == "." {
+			return nil
+		}
+		// The repository's own folder, but not .zippyignore next to it
+		if relPath == ".zippy" || strings.HasPrefix(relPath, ".zippy"+string(filepath.Separator)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if zippyignore.shouldIgnore(relPath) {
+			result.Ignored = append(result.Ignored, relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			result.Files = append(result.Files, relPath)
+			crc, _ := fileCRC32(path)
+			current[filepath.ToSlash(relPath)] = crc
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan working copy: %w", err)
+	}
+
+	// Compare with the latest version
+	versions, err := zippy.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		latest := versions[0]
+		for _, v := range versions[1:] {
+			if v.Timestamp.After(latest.Timestamp) {
+				latest = v
+			}
+		}
+		files, err := zippy.versionFiles(latest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", latest.Tag, err)
+		}
+		changes := compareFiles(files, current)
+		result.Latest = latest.Tag
+		result.Changes = &changes
+	}
+	return result, nil
+}
+
+// DiffResult lists the files that differ between two versions
+type DiffResult struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Changes
+}
+
+// Diff compares the files of two versions
+func (zippy *Repo) Diff(a, b string) (*DiffResult, error) {
+	// Load version metadata
+	version1, err := zippy.loadVersion(a)
+	if err != nil {
+		return nil, err
+	}
+	version2, err := zippy.loadVersion(b)
+	if err != nil {
+		return nil, err
+	}
+	files1, err := zippy.versionFiles(version1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", a, err)
+	}
+	files2, err := zippy.versionFiles(version2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", b, err)
+	}
+	return &DiffResult{From: a, To: b, Changes: compareFiles(files1, files2)}, nil
+}
+
+func (zippy *Repo) saveVersionInfo(version Version) error {
+	// Save version metadata to JSON file
+	versionFile := filepath.Join(zippy.versionsPath, version.Tag+".json")
+	data, _ := json.MarshalIndent(version, "", "  ")
+	if zippy.config.Encryption != nil {
+		var err error
+		if data, err = zippy.encryptBytes(data); err != nil {
+			return fmt.Errorf("failed to encrypt version metadata: %v", err)
+		}
+	}
+	return os.WriteFile(versionFile, data, 0644)
+}
+
+// loadVersion reads the metadata of a single version by tag
+func (zippy *Repo) loadVersion(tag string) (Version, error) {
+	var v Version
+	data, err := os.ReadFile(filepath.Join(zippy.versionsPath, tag+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return v, notFoundError("version %s not found", tag)
+	}
+	if err != nil {
+		return v, err
+	}
+	if bytes.HasPrefix(data, encryptionMagic) {
+		if data, err = zippy.decryptBytes(data); err != nil {
+			return v, fmt.Errorf("version metadata %s: %v", tag, err)
+		}
+	} else if zippy.config.Encryption != nil {
+		return v, fmt.Errorf("version metadata %s is not encrypted", tag)
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("corrupt version metadata %s: %v", tag, err)
+	}
+	return v, nil
+}
+
+// loadVersions reads the metadata of every version, failing on the first
+// unreadable entry
+func (zippy *Repo) loadVersions() ([]Version, error) {
+	files, err := os.ReadDir(zippy.versionsPath)
+	if err != nil {
+		return nil, err
+	}
+	versions := []Version{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		v, err := zippy.loadVersion(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// archiveReader is an open version archive, either a loose zip or a
+// section of a pack file
+type archiveReader struct {
+	*zip.Reader
+	io.Closer
+}
+
+// openVersionZip opens the archive that holds the files of a version
+func (zippy *Repo) openVersionZip(v Version) (*archiveReader, error) {
+	name := zippy.archiveName(v)
+	offset, size := v.PackOffset, v.Size
+	if v.PackPath == "" {
+		obj, err := zippy.storage.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		offset, size = 0, obj.Size
+	}
+	rc, err := zippy.storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if ra, ok := rc.(io.ReaderAt); ok {
+		zr, err := zip.NewReader(io.NewSectionReader(ra, offset, size), size)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &archiveReader{Reader: zr, Closer: rc}, nil
+	}
+	// Remote objects can't be read at random, so buffer the archive
+	defer rc.Close()
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, size))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return &archiveReader{Reader: zr, Closer: io.NopCloser(nil)}, nil
+}
+
+// Patch adds a file or folder of the working copy to an existing version
+func (zippy *Repo) Patch(version string, addPath string) error {
+	if err := zippy.checkCompression(); err != nil {
+		return err
+	}
+	zippy.logf("Patching version %s with %s...\n", version, addPath)
+	v, err := zippy.loadVersion(version)
+	if err != nil {
+		return err
+	}
+	name := zippy.archiveName(v)
+	if v.PackPath != "" {
+		// Packed versions are written back as a loose archive; gc drops the
+		// stale copy once nothing else uses the pack
+		name = v.Tag + ".zip"
+	}
+	// Create temp dir
+	tempDir, err := os.MkdirTemp("", "zippy_patch_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	// Extract zip to temp dir
+	zipReader, err := zippy.openVersionZip(v)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %w", err)
+	}
+	for _, f := range zipReader.File {
+		if !isLocalEntry(f.Name) {
+			zipReader.Close()
+			return invalidError("version %s has an unsafe path %q", version, f.Name)
+		}
+		outPath := filepath.Join(tempDir, f.Name)
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(outPath, 0755)
+			continue
+		}
+		os.MkdirAll(filepath.Dir(outPath), 0755)
+		outFile, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		if err != nil {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			outFile.Close()
+			continue
+		}
+		io.Copy(outFile, rc)
+		outFile.Close()
+		rc.Close()
+		os.Chtimes(outPath, f.Modified, f.Modified)
+	}
+	zipReader.Close()
+	// Copy new file/folder into temp dir
+	srcPath := filepath.Join(zippy.repoPath, addPath)
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("file/folder to add not found: %w", err)
+	}
+	var copyErr error
+	if info.IsDir() {
+		copyErr = copyDir(srcPath, filepath.Join(tempDir, addPath))
+	} else {
+		os.MkdirAll(filepath.Dir(filepath.Join(tempDir, addPath)), 0755)
+		copyErr = copyFile(srcPath, filepath.Join(tempDir, addPath))
+	}
+	if copyErr != nil {
+		return fmt.Errorf("failed to copy file/folder: %w", copyErr)
+	}
+	// Re-zip everything in temp dir
+	newZip, err := os.CreateTemp("", "zippy_patch_*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create zip: %w", err)
+	}
+	defer os.Remove(newZip.Name())
+	writer := zippy.newZipWriter(newZip, zippy.compressionLevel())
+	fileCount := 0
+	filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(tempDir, path)
+		if rel == "." {
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := writer.CreateHeader(entryHeader(rel, info, zippy.compressionMethod()))
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		fileCount++
+		return nil
+	})
+	writer.Close()
+	newZip.Close()
+	if err := zippy.putFile(name, newZip.Name()); err != nil {
+		return fmt.Errorf("failed to store zip: %w", err)
+	}
+	// Update version metadata
+	if stat, err := os.Stat(newZip.Name()); err == nil {
+		v.Size = stat.Size()
+	}
+	v.ZipPath = name
+	v.PackPath = ""
+	v.PackOffset = 0
+	v.FilesCount = fileCount
+	if v.Signature != "" {
+		// The signed manifest no longer matches the archive
+		v.SignedBy, v.Signature = "", ""
+		zippy.logf("Note: removed the signature of %s, since its files changed.\n", version)
+	}
+	if err := zippy.saveVersionInfo(v); err != nil {
+		return fmt.Errorf("failed to save version metadata: %w", err)
+	}
+	zippy.logf("Patch complete.\n")
+	return nil
+}
+
+// newZipWriter returns a zip writer whose Deflate and Zstandard
+// compressors use the given level. Zero selects each codec's default.
+func (zippy *Repo) newZipWriter(w io.Writer, level int) *zip.Writer {
+	writer := zip.NewWriter(w)
+	deflateLevel := flate.DefaultCompression
+	zstdLevel := zstd.SpeedDefault
+	if level > 0 {
+		deflateLevel = min(level, flate.BestCompression)
+		zstdLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, deflateLevel)
+	})
+	writer.RegisterCompressor(zstd.ZipMethodWinZip, zstd.ZipCompressor(zstd.WithEncoderLevel(zstdLevel)))
+	return writer
+}
+
+// entryHeader returns the zip header for a file, choosing the compression
+// method from method and the file's extension. The mode and modification
+// time come from info; without it the caller sets them.
+func entryHeader(name string, info os.FileInfo, method string) *zip.FileHeader {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if info != nil {
+		header.SetMode(info.Mode())
+		header.Modified = info.ModTime()
+	}
+	switch {
+	case storedExtensions[strings.ToLower(filepath.Ext(name))]:
+		header.Method = zip.Store
+	case method == COMPRESSION_STORE:
+		header.Method = zip.Store
+	case method == COMPRESSION_ZSTD:
+		header.Method = zstd.ZipMethodWinZip
+	}
+	return header
+}
+
+// validateCompression checks a compression method and level from the
+// config file or the command line
+func validateCompression(method string, level int) error {
+	switch method {
+	case "", COMPRESSION_DEFLATE, COMPRESSION_STORE:
+		if level < 0 || level > 9 {
+			return fmt.Errorf("compression level must be between 1 and 9, got %d", level)
+		}
+	case COMPRESSION_ZSTD:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22, got %d", level)
+		}
+	default:
+		return fmt.Errorf("unknown compression method %q (use deflate, zstd or store)", method)
+	}
+	return nil
+}
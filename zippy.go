@@ -2,62 +2,53 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
+	"bytes"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"hash/crc32"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
+
+	"Zippy/repo"
+
+	"golang.org/x/term"
 )
 
 var ZippyVersion = "0.0.1"
 var ZippyAuthor = "PixCap Soft"
 
 const (
-	ZIPPY_NAME    = "Zippy"
-	ZIPPY_DESC    = "Simple Version Control Tool with Zip Storage"
-	ZIPPY_REPO    = "https://github.com/pixcapsoft/Zippy"
-	ZIPPY_STAGE   = "stage.json"
+	ZIPPY_NAME = "Zippy"
+	ZIPPY_DESC = "Simple Version Control Tool with Zip Storage"
+	ZIPPY_REPO = "https://github.com/pixcapsoft/Zippy"
+
+	// Exit status of the zippy command
+	EXIT_OK        = 0
+	EXIT_ERROR     = 1 // any other failure
+	EXIT_USAGE     = 2 // unknown command or option, missing or extra arguments
+	EXIT_NOT_REPO  = 3 // not inside a Zippy repository
+	EXIT_NOT_FOUND = 4 // no such version, file, remote or key
+	EXIT_CONFLICT  = 5 // the version, remote or repository already exists
+	EXIT_IO        = 6 // reading or writing files, storage or the network failed
 )
 
-// Version represents a version entry
-type Version struct {
-	Tag         string    `json:"tag"`
-	Message     string    `json:"message"`
-	Timestamp   time.Time `json:"timestamp"`
-	Author      string    `json:"author"`
-	FilesCount  int       `json:"files_count"`
-	ZipPath     string    `json:"zip_path"`
-	Size        int64     `json:"size"`
-}
-
-// Repository configuration
-type RepoConfig struct {
-	Name        string    `json:"name"`
-	Author      string    `json:"author"`
-	Created     time.Time `json:"created"`
-	Description string    `json:"description"`
-}
-
-// ZippyIgnore handles .zippyignore file parsing
-type ZippyIgnore struct {
-	patterns []string
-}
-
-// Main CLI structure
+// Zippy is the command line front end of a repository
 type Zippy struct {
-	repoPath     string
-	zippyPath    string
-	configPath   string
-	versionsPath string
-	storagePath  string
-	config       RepoConfig
-	stagePath    string
+	repo   *repo.Repo
+	output outputFormat
 }
 
 func main() {
@@ -66,1090 +57,1801 @@ func main() {
 		showHelp()
 		return
 	}
+	err := run(os.Args[1:])
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		var exit *exitError
+		if errors.As(err, &exit) && exit.hint != "" {
+			fmt.Fprintln(os.Stderr, exit.hint)
+		}
+	}
+	os.Exit(exitCode(err))
+}
 
+// run dispatches a command line and returns the error that decides the exit
+// status. The switch is the list of commands: each case declares its
+// options and help, so 'zippy help <command>' runs the command with --help.
+func run(args []string) error {
 	zippy := &Zippy{}
-	command := os.Args[1]
+	// -C, --json and --format may also come before the command
+	global := []string{}
+	for len(args) > 1 {
+		if args[0] == "-C" {
+			if err := os.Chdir(args[1]); err != nil {
+				return fmt.Errorf("cannot change to %s: %w", args[1], err)
+			}
+			args = args[2:]
+			continue
+		}
+		if args[0] != "--json" && !strings.HasPrefix(args[0], "--format") {
+			break
+		}
+		n := 1
+		if args[0] == "--format" && len(args) > 2 {
+			n = 2
+		}
+		global, args = append(global, args[:n]...), args[n:]
+	}
+	if len(args) == 0 {
+		return &exitError{code: EXIT_USAGE, err: errors.New("no command given"), hint: "Run 'zippy help' for available commands"}
+	}
+	command := args[0]
+	args = append(global, args[1:]...)
 
 	switch command {
 	case "init":
-		zippy.initRepo()
+		templatesDir, _ := repo.TemplatesDir()
+		flags := newCommandFlags("init", "[directory] [options]",
+			"Initialize a new Zippy repository in a folder (default: $ZIPPY_DIR or the\n"+
+				"current folder), creating it if needed. Prompts for the repository name,\n"+
+				"author and description unless they are given as options or --yes is\n"+
+				"used. Templates add ignore patterns for a kind of project:\n"+
+				strings.Join(repo.IgnoreTemplates(), ", ")+", or <name>.zippyignore files in\n"+
+				templatesDir+".\n"+
+				"Example: zippy init --name website --template node --yes")
+		var opts repo.InitOptions
+		encrypt, keyFile, author, templates, yes := false, "", "", "", false
+		flags.stringOpt(&opts.Name, "name", "", "name", "name the repository (default: the folder name)")
+		flags.stringOpt(&author, "author", "", "author", "owner of the repository, as \"Name <email>\" (default Unknown)")
+		flags.stringOpt(&opts.Description, "description", "", "text", "describe the repository (default \"Zippy Repository\")")
+		flags.stringOpt(&templates, "template", "", "names", "add the ignore patterns of these comma-separated templates")
+		flags.boolOpt(&yes, "yes", "y", "don't prompt; use the defaults for anything not given")
+		flags.boolOpt(&encrypt, "encrypt", "", "encrypt stored versions with a key protected by a passphrase")
+		flags.stringOpt(&keyFile, "keyfile", "", "path", "protect the key with a key file instead; created if missing")
+		rest, err := flags.parse(args, 0, 1)
+		if err != nil {
+			return err
+		}
+		dir := os.Getenv("ZIPPY_DIR")
+		if len(rest) == 1 {
+			dir = rest[0]
+		}
+		opts.Author, opts.Email = repo.ParseAuthor(author)
+		for _, name := range strings.Split(templates, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.Templates = append(opts.Templates, name)
+			}
+		}
+		if encrypt {
+			opts.Encryption = repo.ENCRYPTION_PASSPHRASE
+		}
+		if keyFile != "" {
+			opts.Encryption, opts.KeyFile = repo.ENCRYPTION_KEYFILE, keyFile
+		}
+		return zippy.initRepo(dir, opts, !yes)
 	case "add":
+		flags := newCommandFlags("add", "<files|folders|.>...",
+			"Stage files or folders for the next commit. Paths are relative to the\n"+
+				"current folder; '.' stages all of its files except those in .zippyignore.\n"+
+				"Example: zippy add main.go src/ .env")
+		paths, err := flags.parse(args, 1, -1)
+		if err != nil {
+			return err
+		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: zippy add <files>")
-			return
+		for i, path := range paths {
+			if paths[i], err = zippy.repoPath(path); err != nil {
+				return err
+			}
 		}
-		zippy.addFiles(os.Args[2:])
+		return zippy.addFiles(paths)
 	case "commit":
+		flags := newCommandFlags("commit", "[options]",
+			"Create a new version from the staged files. Already-compressed files\n"+
+				"(png, jpg, mp4, zip, ...) are always stored as-is. You are credited as\n"+
+				"ZIPPY_AUTHOR_NAME and ZIPPY_AUTHOR_EMAIL, or else the author and email in\n"+
+				"the user config, or else those of the repository.\n"+
+				"Example: zippy commit -m \"Initial commit\" -t v1.0")
+		message, tag, method, level, sign, keyPath, author := "", "", "", 0, false, "", ""
+		flags.stringOpt(&message, "message", "m", "text", "describe the version (default \"No message\")")
+		flags.stringOpt(&tag, "tag", "t", "tag", "name the version (default v<unix time>)")
+		flags.alias("v", "tag")
+		flags.stringOpt(&method, "method", "", "method", "compress with deflate, zstd or store instead of the configured method")
+		flags.intOpt(&level, "level", "", "level", "compression level, 1 (fastest) to 9 (best), or up to 22 for zstd")
+		flags.boolOpt(&sign, "sign", "", "sign the version with your Ed25519 key (see 'zippy help key generate')")
+		flags.stringOpt(&keyPath, "key", "", "path", "sign with this key file instead")
+		flags.stringOpt(&author, "author", "", "author", "credit the version to \"Name <email>\" instead of you")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		name, email := repo.ParseAuthor(author)
+		return zippy.commit(repo.CommitOptions{
+			Message: message,
+			Tag:     tag,
+			Author:  name,
+			Email:   email,
+			Method:  method,
+			Level:   level,
+			Sign:    sign,
+			KeyPath: keyPath,
+		})
+	case "push", "pull":
+		flags := newCommandFlags(command, "<remote> [versions...]",
+			"Send the versions the remote doesn't have yet, or just the given ones.\n"+
+				"A new or empty directory is set up as a bare repository on first push.\n"+
+				"Example: zippy push backup")
+		if command == "pull" {
+			flags.description = "Fetch the versions this repository doesn't have yet, or just the given\n" +
+				"ones. The working copy is not changed; use 'zippy restore' afterwards.\n" +
+				"Example: zippy pull origin v2.0"
+		}
+		rest, err := flags.parse(args, 1, -1)
+		if err != nil {
+			return err
+		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
+		}
+		if command == "push" {
+			return zippy.repo.Push(rest[0], rest[1:])
+		} else {
+			return zippy.repo.Pull(rest[0], rest[1:])
+		}
+	case "remote":
+		group := newCommandFlags("remote", "[list | add <name> <path|url> | remove <name>]",
+			"Manage remotes: other Zippy repositories, bare repository directories (for\n"+
+				"example on a shared drive) or http:// servers started with 'zippy serve',\n"+
+				"that versions are pushed to and pulled from. Set ZIPPY_TOKEN for servers\n"+
+				"that require a token.\n"+
+				"Example: zippy remote add backup /mnt/shared/myproject")
+		if len(args) == 0 {
+			args = []string{"list"}
+		}
+		sub, rest, err := group.subcommand(args)
+		if err != nil {
+			return err
+		}
+		switch sub {
+		case "list":
+			flags := newCommandFlags("remote list", "", "List the remotes of this repository.")
+			if _, err := flags.parse(rest, 0, 0); err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.listRemotes()
+		case "add":
+			flags := newCommandFlags("remote add", "<name> <path|url>", "Add a remote: a folder, a bundle file or an http:// URL.")
+			rest, err := flags.parse(rest, 2, 2)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.AddRemote(rest[0], rest[1])
+		case "remove":
+			flags := newCommandFlags("remote remove", "<name>", "Remove a remote.")
+			rest, err := flags.parse(rest, 1, 1)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.RemoveRemote(rest[0])
+		default:
+			return group.fail("unknown command 'remote %s'", sub)
+		}
+	case "serve":
+		flags := newCommandFlags("serve", "[options]",
+			"Serve this repository over HTTP so others can clone, pull and push with an\n"+
+				"http:// remote. With a token every request must carry it and uploads are\n"+
+				"accepted; without one the server is read-only. An encrypted repository\n"+
+				"is served decrypted and needs a token.\n"+
+				"Example: zippy serve --addr :8080 --token s3cret")
+		addr, token := "localhost:8080", os.Getenv("ZIPPY_TOKEN")
+		flags.stringOpt(&addr, "addr", "", "host:port", "address to listen on")
+		flags.stringOpt(&token, "token", "", "token", "require this bearer token (default $ZIPPY_TOKEN)")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
 		}
-		zippy.commit()
-	case "push":
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
+		}
+		return zippy.repo.Serve(addr, token)
+	case "bundle":
+		group := newCommandFlags("bundle", "create <file> [versions...] | verify <file> | import <file>",
+			"Carry versions between machines as a single file. A bundle can also be\n"+
+				"used as a remote for pull and clone.")
+		sub, rest, err := group.subcommand(args)
+		if err != nil {
+			return err
 		}
-		zippy.push()
+		switch sub {
+		case "create":
+			flags := newCommandFlags("bundle create", "<file> [versions...]",
+				"Write versions (default: all) and their archives to a single file for\n"+
+					"offline transfer. Versions are tags or ranges: v1..v5 (inclusive), v3..\n"+
+					"(to the newest) or ..v2 (from the oldest).\n"+
+					"Example: zippy bundle create release.zbundle v1.0..v2.0")
+			rest, err := flags.parse(rest, 1, -1)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.CreateBundle(rest[0], rest[1:])
+		case "verify":
+			flags := newCommandFlags("bundle verify", "<file>",
+				"Check the header and every archive of a bundle against its checksums.")
+			rest, err := flags.parse(rest, 1, 1)
+			if err != nil {
+				return err
+			}
+			return repo.VerifyBundle(rest[0], os.Stdout)
+		case "import":
+			flags := newCommandFlags("bundle import", "<file>",
+				"Add the versions of a bundle to this repository, skipping those it\n"+
+					"already has.")
+			rest, err := flags.parse(rest, 1, 1)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.ImportBundle(rest[0])
+		default:
+			return group.fail("unknown command 'bundle %s'", sub)
+		}
+	case "export":
+		group := newCommandFlags("export", "git <directory>", "Write the history of this repository to another tool.")
+		sub, rest, err := group.subcommand(args)
+		if err != nil {
+			return err
+		}
+		switch sub {
+		case "git":
+			flags := newCommandFlags("export git", "<directory>",
+				"Write every version, oldest first, as a commit in a new git repository,\n"+
+					"keeping its message, author and date, and tag each commit with its\n"+
+					"version tag. Run 'git reset --hard' there to check out the files.\n"+
+					"Example: zippy export git ../myproject-git")
+			rest, err := flags.parse(rest, 1, 1)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.ExportGit(rest[0])
+		default:
+			return group.fail("unknown command 'export %s'", sub)
+		}
+	case "import":
+		group := newCommandFlags("import", "git <path> | archive <file>", "Create versions from history kept elsewhere.")
+		sub, rest, err := group.subcommand(args)
+		if err != nil {
+			return err
+		}
+		switch sub {
+		case "git":
+			flags := newCommandFlags("import git", "<path> [options]",
+				"Create versions from a git repository, read directly from its .git folder.\n"+
+					"Messages, authors and dates are kept and .zippyignore applies. Versions\n"+
+					"that already exist are skipped.\n"+
+					"Example: zippy import git ../myproject --branch main")
+			branch, tags := "", false
+			flags.boolOpt(&tags, "tags", "", "make one version per tag (the default when the repository has tags)")
+			flags.stringOpt(&branch, "branch", "", "name", "make one version per commit on a branch, tagged with the short commit hash")
+			rest, err := flags.parse(rest, 1, 1)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.ImportGit(rest[0], branch, tags)
+		case "archive":
+			flags := newCommandFlags("import archive", "<file> [options]",
+				"Create a version from a zip, tar, tar.gz or tar.zst archive, dated by its\n"+
+					"newest file.\n"+
+					"Example: zippy import archive releases/project-1.1.tar.gz -t v1.1 --strip")
+			tag, message, strip := "", "", false
+			flags.stringOpt(&tag, "tag", "t", "tag", "name the version (default: the file name without its extension)")
+			flags.alias("v", "tag")
+			flags.stringOpt(&message, "message", "m", "text", "describe the version (default \"Imported from <file>\")")
+			flags.boolOpt(&strip, "strip", "", "drop the leading directory, such as project-1.0/")
+			rest, err := flags.parse(rest, 1, 1)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			return zippy.repo.ImportArchive(rest[0], tag, message, strip)
+		default:
+			return group.fail("unknown command 'import %s'", sub)
+		}
+	case "clone":
+		flags := newCommandFlags("clone", "<source> <directory>",
+			"Create a new working copy from a repository, with all of its versions and\n"+
+				"the files of its newest version. The source is saved as remote 'origin'.\n"+
+				"Example: zippy clone /mnt/shared/myproject myproject")
+		rest, err := flags.parse(args, 2, 2)
+		if err != nil {
+			return err
+		}
+		_, err = repo.Clone(rest[0], rest[1], repo.CloneOptions{Log: os.Stdout, Passphrase: readPassphrase})
+		return err
 	case "list", "ls":
+		flags := newCommandFlags(command, "[options]",
+			"List all saved versions with their tags, dates, authors and messages.\n"+
+				"With --format the template runs once per version.\n"+
+				"Example: zippy list --author alice@example.com")
+		author := ""
+		flags.stringOpt(&author, "author", "", "text", "only versions whose author name or email contains text, ignoring case")
+		flags.outputOpts(&zippy.output, "{{.Tag}} {{.Author}}")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		return zippy.listVersions(author)
+	case "log":
+		flags := newCommandFlags("log", "[options]",
+			"Show versions newest first, with their authors, dates, sizes and file\n"+
+				"counts. Dates are YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339, or a time\n"+
+				"ago such as 36h, 2d or 3w.\n"+
+				"Example: zippy log --since 2w --author alice --path src/app.go")
+		var opts repo.LogOptions
+		since, until, grep, path, oneline := "", "", "", "", false
+		flags.stringOpt(&since, "since", "", "date", "only versions created at or after date")
+		flags.stringOpt(&until, "until", "", "date", "only versions created at or before date")
+		flags.stringOpt(&opts.Author, "author", "", "text", "only versions whose author name or email contains text, ignoring case")
+		flags.stringOpt(&grep, "grep", "", "regexp", "only versions whose message matches regexp")
+		flags.stringOpt(&path, "path", "", "path", "only versions that added, changed or removed this file or folder")
+		flags.intOpt(&opts.Limit, "limit", "n", "count", "show at most count versions")
+		flags.boolOpt(&opts.Reverse, "reverse", "", "show the oldest first")
+		flags.boolOpt(&oneline, "oneline", "", "show one line per version")
+		flags.outputOpts(&zippy.output, "{{.Tag}} {{.Timestamp}}")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
+		var err error
+		if since != "" {
+			if opts.Since, err = parseTime(since, false); err != nil {
+				return err
+			}
+		}
+		if until != "" {
+			if opts.Until, err = parseTime(until, true); err != nil {
+				return err
+			}
+		}
+		if grep != "" {
+			if opts.Grep, err = regexp.Compile(grep); err != nil {
+				return usageError("invalid --grep: %v", err)
+			}
+		}
+		if opts.Limit < 0 {
+			return usageError("--limit must not be negative")
+		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
+		}
+		if path != "" {
+			if opts.Path, err = zippy.repoPath(path); err != nil {
+				return err
+			}
 		}
-		zippy.listVersions()
+		return zippy.log(opts, oneline)
+	case "show":
+		flags := newCommandFlags("show", "<version> [options]",
+			"Show everything about a version: its metadata, sizes, file count and\n"+
+				"the changes from the version before it.\n"+
+				"Example: zippy show v1.0 --files")
+		files := false
+		flags.boolOpt(&files, "files", "", "also list the files of the archive as a tree, with sizes and modes")
+		flags.outputOpts(&zippy.output, "{{.Tag}} {{.UncompressedSize}}")
+		rest, err := flags.parse(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		return zippy.show(rest[0], files)
+	case "cat":
+		flags := newCommandFlags("cat", "<version> <path> | <version>:<path> [options]",
+			"Print a file as it was in a version, without touching the working copy.\n"+
+				"The path is relative to the current folder.\n"+
+				"Example: zippy cat v1.0:config/app.yaml | diff - config/app.yaml")
+		output := ""
+		flags.stringOpt(&output, "output", "o", "file", "write the file to this path instead of stdout")
+		rest, err := flags.parse(args, 1, 2)
+		if err != nil {
+			return err
+		}
+		tag, path := rest[0], ""
+		if len(rest) == 2 {
+			path = rest[1]
+		} else if t, p, ok := strings.Cut(rest[0], ":"); ok && t != "" && p != "" {
+			tag, path = t, p
+		} else {
+			return usageError("missing path (use <version> <path> or <version>:<path>)")
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		if path, err = zippy.repoPath(path); err != nil {
+			return err
+		}
+		return zippy.cat(tag, path, output)
+	case "history":
+		flags := newCommandFlags("history", "<path> [options]",
+			"Show the versions, oldest first, that added, changed, renamed or removed\n"+
+				"a file, with its CRC-32, the change in size and the message. A file\n"+
+				"that moved with the same contents is followed under its new name.\n"+
+				"Example: zippy history config/app.yaml -p")
+		patch := false
+		flags.boolOpt(&patch, "patch", "p", "show the changes to the contents at each step")
+		flags.outputOpts(&zippy.output, "{{.Tag}} {{.Action}} {{.SizeDelta}}")
+		rest, err := flags.parse(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		path, err := zippy.repoPath(rest[0])
+		if err != nil {
+			return err
+		}
+		return zippy.history(path, patch)
+	case "grep":
+		flags := newCommandFlags("grep", "<regexp> [versions...] [-- paths...]",
+			"Search the text files of versions for lines matching a Go regular\n"+
+				"expression, without extracting them. Searches every version, oldest\n"+
+				"first, unless versions are given; paths after -- limit the search to\n"+
+				"those files and folders, relative to the current folder. Prints\n"+
+				"version:path:line:text for each match.\n"+
+				"Example: zippy grep -i 'OldClient\\(' v1.0 v2.0 -- src")
+		var opts repo.GrepOptions
+		ignoreCase := false
+		flags.boolOpt(&ignoreCase, "ignore-case", "i", "ignore case")
+		flags.boolOpt(&opts.FilesOnly, "files-with-matches", "l", "print only version:path of each file that matches")
+		flags.intOpt(&opts.Jobs, "jobs", "j", "count", "search at most count versions at once (default: the number of CPUs)")
+		flags.outputOpts(&zippy.output, "{{.Tag}} {{.Path}}:{{.Line}}")
+		// Paths come after --, which parse would treat as more arguments
+		var paths []string
+		if i := slices.Index(args, "--"); i >= 0 {
+			args, paths = args[:i], args[i+1:]
+		}
+		rest, err := flags.parse(args, 1, -1)
+		if err != nil {
+			return err
+		}
+		pattern := rest[0]
+		if ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		if opts.Pattern, err = regexp.Compile(pattern); err != nil {
+			return usageError("invalid regexp: %v", err)
+		}
+		if opts.Jobs < 0 {
+			return usageError("--jobs must not be negative")
+		}
+		opts.Tags = rest[1:]
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		for _, p := range paths {
+			rel, err := zippy.repoPath(p)
+			if err != nil {
+				return err
+			}
+			opts.Paths = append(opts.Paths, rel)
+		}
+		return zippy.grep(opts)
 	case "restore":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: zippy restore <version> [path]")
-			return
+		flags := newCommandFlags("restore", "<version> [path] [options]",
+			"Restore all files from a version, or a specific file or folder if a path is\n"+
+				"given, relative to the current folder. With --json or --format each file\n"+
+				"is reported with its path, status (restored or failed) and error.\n"+
+				"Example: zippy restore v1.0 src/main.go")
+		flags.outputOpts(&zippy.output, "{{.Status}} {{.Path}}")
+		rest, err := flags.parse(args, 1, 2)
+		if err != nil {
+			return err
 		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
 		var restorePath string
-		if len(os.Args) >= 4 {
-			restorePath = os.Args[3]
+		if len(rest) == 2 {
+			if restorePath, err = zippy.repoPath(rest[1]); err != nil {
+				return err
+			}
 		}
-		zippy.restore(os.Args[2], restorePath)
+		return zippy.restore(rest[0], restorePath)
 	case "status":
+		flags := newCommandFlags("status", "[options]",
+			"Show the files staged for commit, the ignored files and the changes\n"+
+				"compared to the latest version. The result has files, ignored, latest,\n"+
+				"added, removed and changed.")
+		flags.outputOpts(&zippy.output, "{{len .Changed}} changed")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
-		zippy.status()
+		return zippy.status()
 	case "diff":
-		if len(os.Args) < 4 {
-			fmt.Println("Usage: zippy diff <version1> <version2>")
-			return
+		flags := newCommandFlags("diff", "<version1> <version2> [options]",
+			"Show which files were added, removed, or changed between two versions.\n"+
+				"The result has from, to, added, removed and changed.\n"+
+				"Example: zippy diff v1.0 v2.0 --json")
+		flags.outputOpts(&zippy.output, "{{range .Added}}{{.}} {{end}}")
+		rest, err := flags.parse(args, 2, 2)
+		if err != nil {
+			return err
 		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
-		zippy.diff(os.Args[2], os.Args[3])
+		return zippy.diff(rest[0], rest[1])
 	case "version", "-v", "--version":
+		flags := newCommandFlags("version", "", "Show Zippy version information.")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
 		showVersion()
 	case "help", "-h", "--help":
+		if len(args) > 0 {
+			return run(append(args, "--help"))
+		}
 		showHelp()
 	case "about", "info":
+		flags := newCommandFlags(command, "", "Show detailed information about Zippy.")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
 		showAbout()
 	case "patch":
-		if len(os.Args) < 4 {
-			fmt.Println("Usage: zippy patch <version> <file/folder>")
-			return
+		flags := newCommandFlags("patch", "<version> <file|folder>",
+			"Add a file or folder to an existing version (modifies the zip and metadata).\n"+
+				"Example: zippy patch v1.0 README.md")
+		rest, err := flags.parse(args, 2, 2)
+		if err != nil {
+			return err
 		}
 		if err := zippy.initPaths(); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
+		}
+		path, err := zippy.repoPath(rest[1])
+		if err != nil {
+			return err
 		}
-		zippy.patchVersion(os.Args[2], os.Args[3])
+		return zippy.repo.Patch(rest[0], path)
+	case "key":
+		group := newCommandFlags("key", "generate | public | trust | rotate",
+			"Manage signing keys and the encryption key of the repository.")
+		sub, rest, err := group.subcommand(args)
+		if err != nil {
+			return err
+		}
+		switch sub {
+		case "generate", "public":
+			flags := newCommandFlags("key generate", "[path]",
+				"Create an Ed25519 signing key (default: ~/.config/zippy/signing.key, or\n"+
+					"ZIPPY_SIGNING_KEY) and print its public key.")
+			if sub == "public" {
+				flags = newCommandFlags("key public", "[path]", "Print the public key of a signing key.")
+			}
+			rest, err := flags.parse(rest, 0, 1)
+			if err != nil {
+				return err
+			}
+			path, err := repo.SigningKeyPath()
+			if len(rest) == 1 {
+				path, err = rest[0], nil
+			}
+			if err != nil {
+				return err
+			}
+			var public ed25519.PublicKey
+			if sub == "generate" {
+				public, err = repo.GenerateSigningKey(path)
+			} else if private, perr := repo.LoadSigningKey(path); perr != nil {
+				err = perr
+			} else {
+				public = private.Public().(ed25519.PublicKey)
+			}
+			if err != nil {
+				return err
+			}
+			if sub == "generate" {
+				fmt.Printf("Created signing key %s\n", path)
+				fmt.Println("Share the public key below so others can 'zippy key trust' it:")
+			}
+			fmt.Println(repo.FormatPublicKey(public))
+		case "trust":
+			flags := newCommandFlags("key trust", "<public-key> [name]",
+				"Add a public key to the trusted keys in .zippy/config.json.")
+			rest, err := flags.parse(rest, 1, 2)
+			if err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			name := ""
+			if len(rest) == 2 {
+				name = rest[1]
+			}
+			return zippy.repo.TrustKey(rest[0], name)
+		case "rotate":
+			flags := newCommandFlags("key rotate", "[options]",
+				"Re-encrypt an encrypted repository under a new key. Without options the\n"+
+					"current passphrase or key file mode is kept. An interrupted rotation is\n"+
+					"resumed by running the command again.")
+			passphrase, keyFile := false, ""
+			flags.boolOpt(&passphrase, "passphrase", "", "protect the new key with a passphrase")
+			flags.stringOpt(&keyFile, "keyfile", "", "path", "protect the new key with a key file")
+			if _, err := flags.parse(rest, 0, 0); err != nil {
+				return err
+			}
+			if err := zippy.initPaths(); err != nil {
+				return err
+			}
+			mode := ""
+			if passphrase {
+				mode = repo.ENCRYPTION_PASSPHRASE
+			}
+			if keyFile != "" {
+				mode = repo.ENCRYPTION_KEYFILE
+			}
+			return zippy.repo.RotateKey(mode, keyFile)
+		default:
+			return group.fail("unknown command 'key %s'", sub)
+		}
+	case "verify":
+		flags := newCommandFlags("verify", "[options] [versions...]",
+			"Read every file of the given versions (default: all) and check the\n"+
+				"archives are intact.\n"+
+				"Example: zippy verify --signatures v1.0 v2.0")
+		signatures := false
+		flags.boolOpt(&signatures, "signatures", "", "also require each version to be signed by a trusted key")
+		tags, err := flags.parse(args, 0, -1)
+		if err != nil {
+			return err
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		return zippy.verify(tags, signatures)
+	case "config":
+		group := newCommandFlags("config", "get <key> | set <key> <value> | unset <key> | list",
+			"Read and change settings. Without --global they are kept in\n"+
+				".zippy/config.json; with --global in the user config file, which applies\n"+
+				"to all your repositories. Repository settings take precedence, except\n"+
+				"author and email, where yours do.\n"+
+				"Keys: "+strings.Join(repo.ConfigKeys(), ", ")+"\n"+
+				"Example: zippy config set --global email alice@example.com")
+		if len(args) == 0 {
+			args = []string{"list"}
+		}
+		sub, rest, err := group.subcommand(args)
+		if err != nil {
+			return err
+		}
+		global := false
+		var flags *commandFlags
+		min, max := 0, 0
+		switch sub {
+		case "get":
+			flags, min, max = newCommandFlags("config get", "<key> [--global]", "Print the value of a setting."), 1, 1
+		case "set":
+			flags, min, max = newCommandFlags("config set", "<key> <value> [--global]", "Change a setting."), 2, 2
+		case "unset":
+			flags, min, max = newCommandFlags("config unset", "<key> [--global]", "Remove a setting, so its default applies."), 1, 1
+		case "list":
+			flags = newCommandFlags("config list", "[--global] [options]",
+				"List the settings that are set and where they come from. The result has\n"+
+					"key, value and scope (repo or user).")
+			flags.outputOpts(&zippy.output, "{{.Key}}={{.Value}}")
+		default:
+			return group.fail("unknown command 'config %s'", sub)
+		}
+		flags.boolOpt(&global, "global", "", "use the user config file instead of the repository")
+		rest, err = flags.parse(rest, min, max)
+		if err != nil {
+			return err
+		}
+		if !global {
+			err := zippy.initPaths()
+			// Outside a repository reading falls back to the user config
+			if errors.Is(err, repo.ErrNotRepo) && (sub == "get" || sub == "list") {
+				global = true
+			} else if err != nil {
+				return err
+			}
+		}
+		switch {
+		case sub == "set" && global:
+			return repo.SetUserConfig(rest[0], rest[1])
+		case sub == "set":
+			return zippy.repo.SetConfig(rest[0], rest[1])
+		case sub == "unset" && global:
+			return repo.UnsetUserConfig(rest[0])
+		case sub == "unset":
+			return zippy.repo.UnsetConfig(rest[0])
+		}
+		settings, err := zippy.settings(global)
+		if err != nil {
+			return err
+		}
+		if sub == "list" {
+			return zippy.listSettings(settings)
+		}
+		return zippy.getSetting(settings, rest[0])
+	case "gc":
+		flags := newCommandFlags("gc", "[options]",
+			"Remove archives in storage that no version references, pack small versions\n"+
+				"together and report the bytes reclaimed.")
+		aggressive, dryRun := false, false
+		flags.boolOpt(&aggressive, "aggressive", "", "also recompress every archive at the maximum level")
+		flags.boolOpt(&dryRun, "dry-run", "n", "only report what would be removed")
+		if _, err := flags.parse(args, 0, 0); err != nil {
+			return err
+		}
+		if err := zippy.initPaths(); err != nil {
+			return err
+		}
+		return zippy.repo.GC(aggressive, dryRun)
 	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Run 'zippy help' for available commands")
-	}
-}
-
-// initPaths initializes the Zippy paths and checks if repo exists
-func (zippy *Zippy) initPaths() error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("failed to get current directory: %v", err)
-	}
-	
-	zippy.repoPath = cwd
-	zippy.zippyPath = filepath.Join(cwd, ".zippy")
-	zippy.configPath = filepath.Join(zippy.zippyPath, "config.json")
-	zippy.versionsPath = filepath.Join(zippy.zippyPath, "versions")
-	zippy.storagePath = filepath.Join(zippy.zippyPath, "storage")
-	zippy.stagePath = filepath.Join(zippy.zippyPath, ZIPPY_STAGE)
-
-	// Check if repository is initialized
-	if _, err := os.Stat(zippy.zippyPath); os.IsNotExist(err) {
-		return fmt.Errorf("not a Zippy repository. Run 'zippy init' first")
-	}
-
-	// Load config if it exists
-	if configData, err := os.ReadFile(zippy.configPath); err == nil {
-		json.Unmarshal(configData, &zippy.config)
+		return &exitError{
+			code: EXIT_USAGE,
+			err:  fmt.Errorf("unknown command: %s", command),
+			hint: "Run 'zippy help' for available commands",
+		}
 	}
-
 	return nil
 }
 
-func showBanner() {
-	fmt.Printf(`
- ███████╗██╗██████╗ ██████╗ ██╗   ██╗
- ╚══███╔╝██║██╔══██╗██╔══██╗╚██╗ ██╔╝
-   ███╔╝ ██║██████╔╝██████╔╝ ╚████╔╝ 
-  ███╔╝  ██║██╔═══╝ ██╔═══╝   ╚██╔╝  
- ███████╗██║██║     ██║        ██║   
- ╚══════╝╚═╝╚═╝     ╚═╝        ╚═╝   
-
-%s v%s - %s
-%s
+// exitError is an error that ends zippy with a particular exit status
+type exitError struct {
+	code int
+	err  error
+	hint string // printed after the error, such as where to find usage
+}
 
-`, ZIPPY_NAME, ZippyVersion, ZIPPY_DESC, ZIPPY_REPO)
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// Is lets callers test not-found and conflict errors with fs.ErrNotExist
+// and fs.ErrExist, like errors from the os package
+func (e *exitError) Is(target error) bool {
+	return (e.code == EXIT_NOT_FOUND && target == fs.ErrNotExist) || (e.code == EXIT_CONFLICT && target == fs.ErrExist)
 }
 
-func showVersion() {
-	fmt.Printf("%s version %s\n", ZIPPY_NAME, ZippyVersion)
-	fmt.Printf("Build: %s\n", time.Now().Format("2006-01-02"))
-	fmt.Printf("Author: %s\n", ZippyAuthor)
+func usageError(format string, args ...any) error {
+	return &exitError{code: EXIT_USAGE, err: fmt.Errorf(format, args...)}
 }
 
-func showAbout() {
-	showBanner()
-	fmt.Printf("About %s:\n", ZIPPY_NAME)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Printf("Version: %s\n", ZippyVersion)
-	fmt.Printf("Description: %s\n", ZIPPY_DESC)
-	fmt.Printf("Author: %s\n", ZippyAuthor)
-	fmt.Printf("Repository: %s\n", ZIPPY_REPO)
-	fmt.Println()
-	fmt.Println("Features:")
-	fmt.Println("  • Simple version control with zip storage")
-	fmt.Println("  • .zippyignore support (like .gitignore)")
-	fmt.Println("  • Human-readable version tags")
-	fmt.Println("  • Easy backup and sharing")
-	fmt.Println("  • Cross-platform compatibility")
-	fmt.Println()
-	fmt.Println("For more information, visit:", ZIPPY_REPO)
+func notFoundError(format string, args ...any) error {
+	return &exitError{code: EXIT_NOT_FOUND, err: fmt.Errorf(format, args...)}
 }
 
-func showHelp() {
-	help := `
-Zippy - Simple Version Control Tool with Zip Storage
+func conflictError(format string, args ...any) error {
+	return &exitError{code: EXIT_CONFLICT, err: fmt.Errorf(format, args...)}
+}
 
-USAGE:
-  zippy <command> [arguments]
+// exitCode picks the exit status for the error a command returned
+func exitCode(err error) int {
+	var exit *exitError
+	var pathErr *fs.PathError
+	var urlErr *url.Error
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return EXIT_OK
+	case errors.As(err, &exit):
+		return exit.code
+	case errors.Is(err, repo.ErrNotRepo):
+		return EXIT_NOT_REPO
+	case errors.Is(err, repo.ErrInvalid):
+		return EXIT_USAGE
+	case errors.Is(err, fs.ErrNotExist):
+		return EXIT_NOT_FOUND
+	case errors.Is(err, fs.ErrExist):
+		return EXIT_CONFLICT
+	case errors.As(err, &pathErr) || errors.As(err, &urlErr) || errors.Is(err, io.ErrUnexpectedEOF):
+		return EXIT_IO
+	}
+	return EXIT_ERROR
+}
 
-COMMANDS:
-  init
-      Initialize a new Zippy repository. Prompts for repo name, author, and description.
+// outputFormat is how read commands report their results: as text, as
+// JSON with --json, or through a Go template with --format
+type outputFormat struct {
+	json     bool
+	template *template.Template
+}
 
-  add <files|folders|.>
-      Stage files or folders for the next commit. Use '.' to stage all files (except those in .zippyignore).
-      Example: zippy add main.go src/ .env
+// enabled reports whether the command should leave out its usual text
+func (o *outputFormat) enabled() bool {
+	return o.json || o.template != nil
+}
 
-  commit -m "message" -v "tag"
-      Create a new version from staged files. Requires a message and a version tag.
-      Example: zippy commit -m "Initial commit" -v "v1.0"
+// write prints a result as JSON or through the template. The template is
+// run once per element of a slice and once for anything else, each time
+// followed by a newline. Without --json or --format it does nothing.
+func (o *outputFormat) write(value any) error {
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+	if o.template == nil {
+		return nil
+	}
+	items := []any{value}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Slice {
+		items = items[:0]
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, rv.Index(i).Interface())
+		}
+	}
+	for _, item := range items {
+		if err := o.template.Execute(os.Stdout, item); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
 
-  push
-      (Placeholder) Save current version to zip file (already done by commit).
+// formatValue parses --format as soon as it is given, so a broken
+// template is a usage error
+type formatValue struct {
+	o *outputFormat
+}
 
-  list, ls
-      List all saved versions with their tags, dates, authors, and messages.
+func (f formatValue) String() string { return "" }
 
-  restore <version> [path]
-      Restore all files from a version, or a specific file/folder if [path] is given.
-      Example: zippy restore v1.0 src/main.go
+func (f formatValue) Set(text string) error {
+	t, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return err
+	}
+	f.o.template = t
+	return nil
+}
 
-  status
-      Show repository status:
-        - Files staged for commit
-        - Ignored files
-        - Changes compared to the latest version
+// commandFlags holds the options of one command. Options may come before,
+// after or between the arguments; everything after "--" is an argument.
+type commandFlags struct {
+	set         *flag.FlagSet
+	name        string
+	usage       string
+	description string
+	options     [][2]string // names and help of each option, for help
+	output      *outputFormat
+}
 
-  diff <version1> <version2>
-      Show which files were added, removed, or changed between two versions.
-      Example: zippy diff v1.0 v2.0
+// newCommandFlags starts the options of a command. usage lists its
+// arguments and description is shown by 'zippy help <command>'.
+func newCommandFlags(name string, usage string, description string) *commandFlags {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	set.Usage = func() {}
+	return &commandFlags{set: set, name: name, usage: usage, description: description}
+}
 
-  patch <version> <file/folder>
-      Add a file or folder to an existing version (modifies the zip and metadata).
-      Example: zippy patch v1.0 README.md
+// optionNames formats "-m, --message" for help
+func optionNames(long string, short string) string {
+	if short == "" {
+		return "    --" + long
+	}
+	return "-" + short + ", --" + long
+}
 
-  version, -v, --version
-      Show Zippy version information.
+// stringOpt registers a string option with a long and an optional one-letter name
+func (c *commandFlags) stringOpt(p *string, long string, short string, arg string, help string) {
+	c.set.StringVar(p, long, *p, help)
+	c.add(long, short, optionNames(long, short)+" <"+arg+">", help)
+}
 
-  help, -h, --help
-      Show this help message.
+// intOpt registers an integer option
+func (c *commandFlags) intOpt(p *int, long string, short string, arg string, help string) {
+	c.set.IntVar(p, long, *p, help)
+	c.add(long, short, optionNames(long, short)+" <"+arg+">", help)
+}
 
-  about, info
-      Show detailed information about Zippy.
+// boolOpt registers an option that takes no value
+func (c *commandFlags) boolOpt(p *bool, long string, short string, help string) {
+	c.set.BoolVar(p, long, *p, help)
+	c.add(long, short, optionNames(long, short), help)
+}
 
-FILES:
-  .zippyignore
-      List files and patterns to ignore (like .gitignore).
-  .zippy/
-      Zippy metadata directory (do not delete or edit manually).
+// add lists an option in help and registers its one-letter name
+func (c *commandFlags) add(long string, short string, names string, help string) {
+	if short != "" {
+		c.alias(short, long)
+	}
+	c.options = append(c.options, [2]string{names, help})
+}
 
-WORKFLOW EXAMPLES:
-  zippy init
-  zippy add .
-  zippy commit -m "Initial commit" -v "v1.0"
-  zippy list
-  zippy status
-  zippy restore v1.0 src/main.go
-  zippy patch v1.0 README.md
-  zippy diff v1.0 v2.0
+// outputOpts adds --json and --format to a command that reports results.
+// example is a template for the help, such as '{{.Tag}}'.
+func (c *commandFlags) outputOpts(o *outputFormat, example string) {
+	c.boolOpt(&o.json, "json", "", "print the result as JSON")
+	c.set.Var(formatValue{o}, "format", "")
+	c.options = append(c.options, [2]string{"    --format <template>", "print the result through a Go template, e.g. '" + example + "'"})
+	c.output = o
+}
 
-TIPS:
-- Use 'zippy add' before every commit to stage files.
-- Edit .zippyignore to avoid archiving unwanted files.
-- Use 'zippy status' to see what will be committed and what changed.
-- Each commit creates a zip in .zippy/storage and a metadata file in .zippy/versions.
+// alias accepts another name for an option without listing it in help
+func (c *commandFlags) alias(name string, of string) {
+	c.set.Var(c.set.Lookup(of).Value, name, "")
+}
 
-For more information: %s
-`
-	fmt.Printf(help, ZIPPY_REPO)
+// parse reads the options in args and returns the remaining arguments,
+// checking there are between min and max of them (max -1 means any
+// number). On --help it prints the help and returns flag.ErrHelp.
+func (c *commandFlags) parse(args []string, min int, max int) ([]string, error) {
+	rest := []string{}
+	for {
+		err := c.set.Parse(args)
+		if err == flag.ErrHelp {
+			c.help()
+			return nil, err
+		}
+		if err != nil {
+			message := err.Error()
+			if name, ok := strings.CutPrefix(message, "flag provided but not defined: "); ok {
+				// The flag package drops a leading dash; report what was typed
+				for _, arg := range args {
+					if arg == "-"+name || strings.HasPrefix(arg, "-"+name+"=") {
+						name = strings.SplitN(arg, "=", 2)[0]
+						break
+					}
+				}
+				message = "unknown option " + name
+			}
+			return nil, c.fail("%s", message)
+		}
+		remaining := c.set.Args()
+		if len(remaining) == 0 {
+			break
+		}
+		if len(remaining) < len(args) && args[len(args)-len(remaining)-1] == "--" {
+			rest = append(rest, remaining...)
+			break
+		}
+		rest = append(rest, remaining[0])
+		args = remaining[1:]
+	}
+	if len(rest) < min {
+		return nil, c.fail("missing arguments")
+	}
+	if max >= 0 && len(rest) > max {
+		return nil, c.fail("unexpected argument %q", rest[max])
+	}
+	if c.output != nil && c.output.json && c.output.template != nil {
+		return nil, c.fail("--json and --format can't be used together")
+	}
+	return rest, nil
 }
 
-func (zippy *Zippy) initRepo() {
-	cwd, _ := os.Getwd()
-	zippy.repoPath = cwd
-	zippy.zippyPath = filepath.Join(cwd, ".zippy")
-	zippy.configPath = filepath.Join(zippy.zippyPath, "config.json")
-	zippy.versionsPath = filepath.Join(zippy.zippyPath, "versions")
-	zippy.storagePath = filepath.Join(zippy.zippyPath, "storage")
-
-	// Check if already initialized
-	if _, err := os.Stat(zippy.zippyPath); !os.IsNotExist(err) {
-		fmt.Println("Zippy repository already initialized!")
-		return
+// subcommand splits the command of a group such as 'remote add' from its
+// arguments
+func (c *commandFlags) subcommand(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, c.fail("missing command")
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "-help" {
+		c.help()
+		return "", nil, flag.ErrHelp
 	}
+	return args[0], args[1:], nil
+}
 
-	// Prompt for repo details
-	reader := bufio.NewReader(os.Stdin)
-	defaultName := filepath.Base(cwd)
-	fmt.Printf("Repository name [%s]: ", defaultName)
-	name, _ := reader.ReadString('\n')
-	name = strings.TrimSpace(name)
-	if name == "" {
-		name = defaultName
-	}
-	fmt.Print("Author [Unknown]: ")
-	author, _ := reader.ReadString('\n')
-	author = strings.TrimSpace(author)
-	if author == "" {
-		author = "Unknown"
-	}
-	fmt.Print("Description [Zippy Repository]: ")
-	desc, _ := reader.ReadString('\n')
-	desc = strings.TrimSpace(desc)
-	if desc == "" {
-		desc = "Zippy Repository"
-	}
-
-	// Create directories
-	dirs := []string{zippy.zippyPath, zippy.versionsPath, zippy.storagePath}
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			fmt.Printf("Error creating directory %s: %v\n", dir, err)
-			return
-		}
-	}
-
-	// Create config
-	config := RepoConfig{
-		Name:        name,
-		Author:      author, // Could get from git config or environment
-		Created:     time.Now(),
-		Description: desc,
-	}
-
-	configData, _ := json.MarshalIndent(config, "", "  ")
-	if err := os.WriteFile(zippy.configPath, configData, 0644); err != nil {
-		fmt.Printf("Error creating config: %v\n", err)
+// help prints the usage, description and options of the command
+func (c *commandFlags) help() {
+	fmt.Printf("Usage: %s\n\n%s\n", c.usageLine(), c.description)
+	if len(c.options) == 0 {
 		return
 	}
+	width := 0
+	for _, o := range c.options {
+		width = max(width, len(o[0]))
+	}
+	fmt.Println("\nOptions:")
+	for _, o := range c.options {
+		fmt.Printf("  %-*s  %s\n", width, o[0], o[1])
+	}
+}
 
-	// Create sample .zippyignore
-	zippyignoreContent := `# Zippy ignore file
-# Ignore version control directory
-.zippy/
-
-# Common files to ignore
-*.log
-*.tmp
-.DS_Store
-Thumbs.db
-node_modules/
-*.exe
-*.dll
-*.so
-.env
-.env.local
-
-# Add your patterns here
-`
-	zippyignorePath := filepath.Join(cwd, ".zippyignore")
-	os.WriteFile(zippyignorePath, []byte(zippyignoreContent), 0644)
-
-	fmt.Printf("Initialized Zippy repository in %s\n", cwd)
-	fmt.Println("Created .zippyignore file - edit it to specify files to ignore")
+// usageLine is "zippy <command> <arguments>"
+func (c *commandFlags) usageLine() string {
+	return strings.TrimSpace("zippy " + c.name + " " + c.usage)
 }
 
-func (zippy *Zippy) loadZippyIgnore() *ZippyIgnore {
-	zippyignore := &ZippyIgnore{}
-	zippyignorePath := filepath.Join(zippy.repoPath, ".zippyignore")
-	
-	file, err := os.Open(zippyignorePath)
-	if err != nil {
-		return zippyignore // Return empty if file doesn't exist
+// fail returns a usage error that points at the command's help
+func (c *commandFlags) fail(format string, args ...any) error {
+	return &exitError{
+		code: EXIT_USAGE,
+		err:  fmt.Errorf(format, args...),
+		hint: fmt.Sprintf("Usage: %s\nRun 'zippy help %s' for more.", c.usageLine(), c.name),
 	}
-	defer file.Close()
+}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// Skip empty lines and comments
-		if line != "" && !strings.HasPrefix(line, "#") {
-			zippyignore.patterns = append(zippyignore.patterns, line)
+// initPaths opens the repository in ZIPPY_DIR, or else the one the current
+// directory is in
+func (zippy *Zippy) initPaths() error {
+	var r *repo.Repo
+	if dir := os.Getenv("ZIPPY_DIR"); dir != "" {
+		var err error
+		if r, err = repo.Open(dir); err != nil {
+			return err
+		}
+	} else {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %v", err)
+		}
+		if r, err = repo.Find(cwd); err != nil {
+			return err
 		}
 	}
+	r.Log = os.Stdout
+	r.Passphrase = readPassphrase
+	zippy.repo = r
+	return nil
+}
 
-	return zippyignore
+// repoPath turns a path given relative to the current directory into one
+// relative to the root of the working copy
+func (zippy *Zippy) repoPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(zippy.repo.Root(), abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", usageError("%s is outside the repository %s", path, zippy.repo.Root())
+	}
+	return rel, nil
 }
 
-func (zippyignore *ZippyIgnore) shouldIgnore(filePath string) bool {
-	// Normalize filePath to use forward slashes for matching
-	filePath = filepath.ToSlash(filePath)
-	for _, pattern := range zippyignore.patterns {
-		pattern = strings.TrimSpace(pattern)
-		if pattern == "" {
-			continue
-		}
-		// Normalize pattern
-		pattern = filepath.ToSlash(pattern)
-		// Directory pattern (ends with /)
-		if strings.HasSuffix(pattern, "/") {
-			if strings.HasPrefix(filePath, pattern) {
-				return true
-			}
-			continue
-		}
-		// Glob pattern
-		matched, err := filepath.Match(pattern, filePath)
-		if err == nil && matched {
-			return true
+// readPassphrase reads a passphrase from the terminal without echo
+func readPassphrase(prompt string, confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%w without a terminal", repo.ErrNoPassphrase)
+	}
+	fmt.Print(prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	if confirm && len(passphrase) > 0 {
+		fmt.Print("Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, err
 		}
-		// Also match against just the base name (like .gitignore does)
-		if matched, err := filepath.Match(pattern, filepath.Base(filePath)); err == nil && matched {
-			return true
+		if !bytes.Equal(passphrase, again) {
+			return nil, fmt.Errorf("passphrases do not match")
 		}
 	}
-	return false
+	return passphrase, nil
 }
 
-// Update addFiles to write staged files to .zippy/stage.json
-func (zippy *Zippy) addFiles(paths []string) {
-	fmt.Println("Adding files to staging area...")
-	zippyignore := zippy.loadZippyIgnore()
-	staged := map[string]struct{}{}
-	if len(paths) == 1 && paths[0] == "." {
-		filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			relPath, _ := filepath.Rel(zippy.repoPath, path)
-			if relPath == "." {
-				return nil
-			}
-			if zippyignore.shouldIgnore(relPath) {
-				if info.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-			if !info.IsDir() {
-				fmt.Printf("  Added: %s\n", relPath)
-				staged[relPath] = struct{}{}
-			}
-			return nil
-		})
-	} else {
-		for _, p := range paths {
-			absPath := filepath.Join(zippy.repoPath, p)
-			info, err := os.Stat(absPath)
-			if err != nil {
-				fmt.Printf("  [Not found or inaccessible]: %s\n", p)
-				continue
-			}
-			if zippyignore.shouldIgnore(p) {
-				fmt.Printf("  [Ignored]: %s\n", p)
-				continue
-			}
-			if info.IsDir() {
-				filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
-					if err != nil {
-						return err
-					}
-					relPath, _ := filepath.Rel(zippy.repoPath, path)
-					if relPath == "." {
-						return nil
-					}
-					if zippyignore.shouldIgnore(relPath) {
-						if info.IsDir() {
-							return filepath.SkipDir
-						}
-						return nil
-					}
-					if !info.IsDir() {
-						fmt.Printf("  Added: %s\n", relPath)
-						staged[relPath] = struct{}{}
-					}
-					return nil
-				})
-			} else {
-				fmt.Printf("  Added: %s\n", p)
-				staged[p] = struct{}{}
-			}
-		}
+func (zippy *Zippy) initRepo(dir string, opts repo.InitOptions, prompt bool) error {
+	if dir == "" {
+		dir = "."
 	}
-	if len(staged) == 0 {
-		fmt.Println("No files added.")
-	} else {
-		// Save staged files to .zippy/stage.json
-		stageList := make([]string, 0, len(staged))
-		for f := range staged {
-			stageList = append(stageList, f)
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// Check if already initialized before asking anything
+	if _, err := os.Stat(filepath.Join(dir, ".zippy")); !os.IsNotExist(err) {
+		return conflictError("Zippy repository already initialized")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Prompt for the details not given as options; empty answers take the
+	// defaults
+	reader := bufio.NewReader(os.Stdin)
+	ask := func(field *string, question string) {
+		if prompt && *field == "" {
+			fmt.Print(question)
+			answer, _ := reader.ReadString('\n')
+			*field = strings.TrimSpace(answer)
 		}
-		sort.Strings(stageList)
-		data, _ := json.MarshalIndent(stageList, "", "  ")
-		os.WriteFile(zippy.stagePath, data, 0644)
 	}
+	ask(&opts.Name, fmt.Sprintf("Repository name [%s]: ", filepath.Base(dir)))
+	if opts.Author == "" {
+		author := ""
+		ask(&author, "Author [Unknown]: ")
+		opts.Author, opts.Email = repo.ParseAuthor(author)
+	}
+	ask(&opts.Description, "Description [Zippy Repository]: ")
+	opts.Log, opts.Passphrase = os.Stdout, readPassphrase
+
+	r, err := repo.Init(dir, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Initialized Zippy repository in %s\n", r.Root())
+	fmt.Println("Created .zippyignore file - edit it to specify files to ignore")
+	if r.Config().Encryption != nil {
+		fmt.Println("Versions will be encrypted. Without the passphrase or key file they cannot be recovered.")
+	}
+	return nil
 }
 
-func (zippy *Zippy) commit() {
-	// Parse commit message and version tag from args
-	message := "No message"
-	version := fmt.Sprintf("v%d", time.Now().Unix())
-	for i, arg := range os.Args {
-		if arg == "-m" && i+1 < len(os.Args) {
-			message = os.Args[i+1]
-		}
-		if arg == "-v" && i+1 < len(os.Args) {
-			version = os.Args[i+1]
-		}
+func (zippy *Zippy) addFiles(paths []string) error {
+	fmt.Println("Adding files to staging area...")
+	result, err := zippy.repo.Stage(paths)
+	if result == nil {
+		return err
 	}
-	fmt.Printf("Creating version %s: %s\n", version, message)
-	// Read staged files
-	stageList := []string{}
-	if data, err := os.ReadFile(zippy.stagePath); err == nil {
-		json.Unmarshal(data, &stageList)
+	for _, p := range result.Missing {
+		fmt.Printf("  [Not found or inaccessible]: %s\n", p)
 	}
-	if len(stageList) == 0 {
-		fmt.Println("No files staged. Use 'zippy add <files>' to stage files.")
-		return
+	for _, p := range result.Ignored {
+		fmt.Printf("  [Ignored]: %s\n", p)
 	}
-	// Create zip file
-	zipPath := filepath.Join(zippy.storagePath, version+".zip")
-	if err := zippy.createZipFileWithList(zipPath, stageList); err != nil {
-		fmt.Printf("Error creating zip: %v\n", err)
-		return
+	for _, p := range result.Added {
+		fmt.Printf("  Added: %s\n", p)
+	}
+	if len(result.Added) == 0 {
+		fmt.Println("No files added.")
 	}
-	// Save version metadata
-	versionInfo := Version{
-		Tag:       version,
-		Message:   message,
-		Timestamp: time.Now(),
-		Author:    "User", // Could get from config
-		ZipPath:   zipPath,
-		FilesCount: len(stageList),
-	}
-	// Get file info
-	if stat, err := os.Stat(zipPath); err == nil {
-		versionInfo.Size = stat.Size()
-	}
-	zippy.saveVersionInfo(versionInfo)
-	fmt.Printf("Version %s created successfully!\n", version)
-	// Clear staging area
-	os.Remove(zippy.stagePath)
+	return err
 }
 
-func (zippy *Zippy) createZipFile(zipPath string) error {
-	zipFile, err := os.Create(zipPath)
+func (zippy *Zippy) commit(opts repo.CommitOptions) error {
+	v, err := zippy.repo.Commit(opts)
 	if err != nil {
 		return err
 	}
-	defer zipFile.Close()
-
-	writer := zip.NewWriter(zipFile)
-	defer writer.Close()
-
-	zippyignore := zippy.loadZippyIgnore()
-	fileCount := 0
-
-	err = filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, _ := filepath.Rel(zippy.repoPath, path)
-		
-		// Skip if should be ignored
-		if zippyignore.shouldIgnore(relPath) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	if v.SignedBy != "" {
+		fmt.Printf("Signed with %s\n", v.SignedBy)
+	}
+	fmt.Printf("Version %s created successfully!\n", v.Tag)
+	return nil
+}
 
-		if info.IsDir() {
+func (zippy *Zippy) listVersions(author string) error {
+	tags, err := zippy.repo.Tags()
+	if err != nil {
+		return err
+	}
+	versions := []repo.Version{}
+	failed := 0
+	if !zippy.output.enabled() {
+		fmt.Println("Available versions:")
+		fmt.Println("------------------")
+		if len(tags) == 0 {
+			fmt.Println("No versions found.")
 			return nil
 		}
-
-		// Add file to zip
-		file, err := os.Open(path)
+	}
+	for _, tag := range tags {
+		v, err := zippy.repo.Version(tag)
 		if err != nil {
-			return err
+			if zippy.output.enabled() {
+				fmt.Fprintf(os.Stderr, "Warning: cannot read %s.json: %v\n", tag, err)
+			} else {
+				fmt.Printf("  [Error reading %s.json: %v]\n", tag, err)
+			}
+			failed++
+			continue
 		}
-		defer file.Close()
-
-		f, err := writer.Create(relPath)
-		if err != nil {
-			return err
+		if author != "" && !strings.Contains(strings.ToLower(repo.FormatAuthor(v.Author, v.AuthorEmail)), strings.ToLower(author)) {
+			continue
 		}
-
-		_, err = io.Copy(f, file)
-		if err != nil {
-			return err
+		if !zippy.output.enabled() {
+			fmt.Printf("  %s | %s | %s | %s\n", v.Tag, v.Timestamp.Format("2006-01-02 15:04:05"), repo.FormatAuthor(v.Author, v.AuthorEmail), v.Message)
 		}
-
-		fileCount++
-		return nil
-	})
-
-	fmt.Printf("Packed %d files into %s\n", fileCount, filepath.Base(zipPath))
-	return err
+		versions = append(versions, *v)
+	}
+	if err := zippy.output.write(versions); err != nil {
+		return err
+	}
+	// The readable versions are listed, but a script must see the failure
+	if failed > 0 {
+		return fmt.Errorf("%d of %d versions could not be read", failed, len(tags))
+	}
+	return nil
 }
 
-func (zippy *Zippy) createZipFileWithList(zipPath string, files []string) error {
-	zipFile, err := os.Create(zipPath)
+func (zippy *Zippy) log(opts repo.LogOptions, oneline bool) error {
+	versions, err := zippy.repo.VersionLog(opts)
 	if err != nil {
 		return err
 	}
-	defer zipFile.Close()
-	writer := zip.NewWriter(zipFile)
-	defer writer.Close()
-	for _, relPath := range files {
-		absPath := filepath.Join(zippy.repoPath, relPath)
-		info, err := os.Stat(absPath)
-		if err != nil {
+	if zippy.output.enabled() {
+		return zippy.output.write(versions)
+	}
+	if len(versions) == 0 {
+		fmt.Println("No versions found.")
+	}
+	for i, v := range versions {
+		if oneline {
+			fmt.Printf("%s | %s | %s | %d files, %s | %s\n", v.Tag, v.Timestamp.Format("2006-01-02 15:04"),
+				v.Author, v.FilesCount, repo.FormatSize(v.Size), strings.SplitN(v.Message, "\n", 2)[0])
 			continue
 		}
-		if info.IsDir() {
-			// Add all files in the directory
-			filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				rel, _ := filepath.Rel(zippy.repoPath, path)
-				if info.IsDir() {
-					return nil
-				}
-				file, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer file.Close()
-				f, err := writer.Create(rel)
-				if err != nil {
-					return err
-				}
-				_, err = io.Copy(f, file)
-				return err
-			})
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("version %s\n", v.Tag)
+		fmt.Printf("Author: %s\n", repo.FormatAuthor(v.Author, v.AuthorEmail))
+		fmt.Printf("Date:   %s\n", v.Timestamp.Format("2006-01-02 15:04:05 -0700"))
+		fmt.Printf("Files:  %d (%s)\n", v.FilesCount, repo.FormatSize(v.Size))
+		if v.SignedBy != "" {
+			fmt.Printf("Signed: %s\n", v.SignedBy)
+		}
+		fmt.Println()
+		for _, line := range strings.Split(v.Message, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+	return nil
+}
+
+func (zippy *Zippy) show(tag string, files bool) error {
+	details, err := zippy.repo.Show(tag)
+	if err != nil {
+		return err
+	}
+	if zippy.output.enabled() {
+		return zippy.output.write(details)
+	}
+	v := details.Version
+	fmt.Printf("version %s\n", v.Tag)
+	fmt.Printf("Author:    %s\n", repo.FormatAuthor(v.Author, v.AuthorEmail))
+	fmt.Printf("Date:      %s\n", v.Timestamp.Format("2006-01-02 15:04:05 -0700"))
+	if details.Parent != "" {
+		fmt.Printf("Parent:    %s\n", details.Parent)
+	}
+	fmt.Printf("Files:     %d\n", len(details.Files))
+	fmt.Printf("Size:      %s compressed, %s uncompressed\n", repo.FormatSize(v.Size), repo.FormatSize(details.UncompressedSize))
+	if v.PackPath != "" {
+		fmt.Printf("Archive:   %s at offset %d\n", v.PackPath, v.PackOffset)
+	} else {
+		fmt.Printf("Archive:   %s\n", v.ZipPath)
+	}
+	if v.SignedBy != "" {
+		fmt.Printf("Signed by: %s\n", v.SignedBy)
+		fmt.Printf("Signature: %s\n", v.Signature)
+	}
+	fmt.Println()
+	for _, line := range strings.Split(v.Message, "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+	if details.Changes != nil {
+		if details.Parent != "" {
+			fmt.Printf("\nChanges from %s:\n", details.Parent)
 		} else {
-			file, err := os.Open(absPath)
-			if err != nil {
-				continue
-			}
-			defer file.Close()
-			f, err := writer.Create(relPath)
-			if err != nil {
-				continue
-			}
-			io.Copy(f, file)
+			fmt.Println("\nChanges (first version):")
+		}
+		printChanges(details.Changes, "  ", "Added files:", "Removed files:", "Changed files:")
+		if details.Changes.Empty() {
+			fmt.Println("  No changes.")
 		}
 	}
-	fmt.Printf("Packed %d files into %s\n", len(files), filepath.Base(zipPath))
+	if files {
+		fmt.Println("\nFiles:")
+		printTree(details.Files)
+	}
 	return nil
 }
 
-func (zippy *Zippy) push() {
-	fmt.Println("Push completed! Version saved as zip file.")
+// printTree prints archive entries as a tree of folders, with the mode and
+// size of each file
+func printTree(files []repo.FileEntry) {
+	var folders []string // the folders of the previous entry
+	for i, f := range files {
+		parts := strings.Split(f.Path, "/")
+		dirs, name := parts[:len(parts)-1], parts[len(parts)-1]
+		// Keep the folders shared with the previous entry and open the rest
+		common := 0
+		for common < len(dirs) && common < len(folders) && dirs[common] == folders[common] {
+			common++
+		}
+		for depth := common; depth < len(dirs); depth++ {
+			fmt.Printf("%s%s%s/\n", treeIndent(files, i, dirs[:depth]), treeBranch(files, i, dirs[:depth+1]), dirs[depth])
+		}
+		folders = dirs
+		fmt.Printf("%s%s%s  %s  %s\n", treeIndent(files, i, dirs), treeBranch(files, i, parts), name, f.Mode, repo.FormatSize(f.Size))
+	}
 }
 
-func (zippy *Zippy) listVersions() {
-	fmt.Println("Available versions:")
-	fmt.Println("------------------")
-	files, err := os.ReadDir(zippy.versionsPath)
-	if err != nil {
-		fmt.Printf("Error reading versions: %v\n", err)
-		return
+// treeIndent draws the columns left of an entry at the given folder depth:
+// a line for each folder that still has entries after this one
+func treeIndent(files []repo.FileEntry, i int, dirs []string) string {
+	var b strings.Builder
+	for depth := range dirs {
+		if treeHasMore(files, i, dirs[:depth+1]) {
+			b.WriteString("│   ")
+		} else {
+			b.WriteString("    ")
+		}
 	}
-	if len(files) == 0 {
-		fmt.Println("No versions found.")
-		return
+	return b.String()
+}
+
+// treeBranch draws the connector of the entry or folder at path: └── when
+// nothing follows it in its folder
+func treeBranch(files []repo.FileEntry, i int, path []string) string {
+	if treeHasMore(files, i, path) {
+		return "├── "
 	}
-	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-			continue
-		}
-		path := filepath.Join(zippy.versionsPath, file.Name())
-		data, err := os.ReadFile(path)
-		if err != nil {
-			fmt.Printf("  [Error reading %s]\n", file.Name())
-			continue
-		}
-		var v Version
-		if err := json.Unmarshal(data, &v); err != nil {
-			fmt.Printf("  [Corrupt: %s]\n", file.Name())
+	return "└── "
+}
+
+// treeHasMore reports whether an entry after files[i] lives in the same
+// folder as path, without being inside path itself
+func treeHasMore(files []repo.FileEntry, i int, path []string) bool {
+	parent := strings.Join(path[:len(path)-1], "/")
+	own := strings.Join(path, "/")
+	for _, f := range files[i+1:] {
+		if f.Path == own || strings.HasPrefix(f.Path, own+"/") {
 			continue
 		}
-		fmt.Printf("  %s | %s | %s | %s\n", v.Tag, v.Timestamp.Format("2006-01-02 15:04:05"), v.Author, v.Message)
+		return parent == "" || strings.HasPrefix(f.Path, parent+"/")
 	}
+	return false
 }
 
-func (zippy *Zippy) restore(version string, restorePath string) {
-	fmt.Printf("Restoring version %s", version)
-	if restorePath != "" {
-		fmt.Printf(" (path: %s)", restorePath)
+func (zippy *Zippy) cat(tag string, path string, output string) error {
+	rc, err := zippy.repo.OpenFile(tag, path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if output == "" || output == "-" {
+		_, err = io.Copy(os.Stdout, rc)
+		return err
 	}
-	fmt.Println("...")
-	// Load version metadata
-	versionFile := filepath.Join(zippy.versionsPath, version+".json")
-	data, err := os.ReadFile(versionFile)
+	out, err := os.Create(output)
 	if err != nil {
-		fmt.Printf("Version metadata not found: %v\n", err)
-		return
+		return err
 	}
-	var v Version
-	if err := json.Unmarshal(data, &v); err != nil {
-		fmt.Printf("Corrupt version metadata: %v\n", err)
-		return
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
 	}
-	zipPath := v.ZipPath
-	zipReader, err := zip.OpenReader(zipPath)
+	return out.Close()
+}
+
+func (zippy *Zippy) history(path string, patch bool) error {
+	events, err := zippy.repo.History(path)
 	if err != nil {
-		fmt.Printf("Error opening zip: %v\n", err)
-		return
+		return err
 	}
-	defer zipReader.Close()
-	// Normalize restorePath for matching
-	restorePath = filepath.ToSlash(restorePath)
-	restored := false
-	for _, f := range zipReader.File {
-		filePath := filepath.Join(zippy.repoPath, f.Name)
-		zipEntryPath := filepath.ToSlash(f.Name)
-		// If restorePath is set, only restore matching file or folder
-		if restorePath != "" {
-			if zipEntryPath != restorePath && !strings.HasPrefix(zipEntryPath, restorePath+"/") {
-				continue
+	if zippy.output.enabled() {
+		return zippy.output.write(events)
+	}
+	for _, e := range events {
+		action, hash := e.Action, fmt.Sprintf("%08x", e.CRC32)
+		switch e.Action {
+		case repo.FILE_RENAMED:
+			action = fmt.Sprintf("renamed from %s to %s", e.OldPath, e.Path)
+		case repo.FILE_REMOVED:
+			hash = "--------"
+		}
+		delta := "+" + repo.FormatSize(e.SizeDelta)
+		if e.SizeDelta < 0 {
+			delta = "-" + repo.FormatSize(-e.SizeDelta)
+		}
+		fmt.Printf("%s | %s | %s | %s | %s (%s) | %s\n", e.Tag, e.Timestamp.Format("2006-01-02 15:04"),
+			action, hash, repo.FormatSize(e.Size), delta, strings.SplitN(e.Message, "\n", 2)[0])
+		if patch {
+			if err := zippy.printFilePatch(e); err != nil {
+				return err
 			}
 		}
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(filePath, 0755)
-			continue
-		}
-		os.MkdirAll(filepath.Dir(filePath), 0755)
-		outFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	}
+	return nil
+}
+
+// printFilePatch prints the changes a history event made to the file
+func (zippy *Zippy) printFilePatch(e repo.FileEvent) error {
+	read := func(tag string, path string) ([]byte, error) {
+		rc, err := zippy.repo.OpenFile(tag, path)
 		if err != nil {
-			fmt.Printf("  [Error restoring %s]\n", f.Name)
-			continue
+			return nil, err
 		}
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			fmt.Printf("  [Error reading %s]\n", f.Name)
-			continue
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	var before, after []byte
+	var err error
+	fromName, toName := "/dev/null", "/dev/null"
+	if e.Action != repo.FILE_ADDED {
+		oldPath := e.Path
+		if e.OldPath != "" {
+			oldPath = e.OldPath
+		}
+		fromName = e.Previous + ":" + oldPath
+		if before, err = read(e.Previous, oldPath); err != nil {
+			return err
 		}
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
-		if err != nil {
-			fmt.Printf("  [Error writing %s]\n", f.Name)
-			continue
+	}
+	if e.Action != repo.FILE_REMOVED {
+		toName = e.Tag + ":" + e.Path
+		if after, err = read(e.Tag, e.Path); err != nil {
+			return err
 		}
-		fmt.Printf("  Restored: %s\n", f.Name)
-		restored = true
 	}
-	if !restored && restorePath != "" {
-		fmt.Printf("Error: '%s' not found in version %s.\n", restorePath, version)
-	} else if restored {
-		fmt.Println("Restore complete.")
+	if diff := repo.UnifiedDiff(fromName, toName, before, after); diff != "" {
+		fmt.Print("\n" + diff + "\n")
+	} else {
+		fmt.Println()
 	}
+	return nil
 }
 
-func (zippy *Zippy) status() {
-	fmt.Println("Zippy repository status:")
-	zippyignore := zippy.loadZippyIgnore()
-	included := []string{}
-	ignored := []string{}
-	filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		relPath, _ := filepath.Rel(zippy.repoPath, path)
-		if relPath == "." || strings.HasPrefix(relPath, ".zippy") {
-			return nil
-		}
-		if zippyignore.shouldIgnore(relPath) {
-			ignored = append(ignored, relPath)
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		if !info.IsDir() {
-			included = append(included, relPath)
+func (zippy *Zippy) grep(opts repo.GrepOptions) error {
+	matches := []repo.GrepMatch{}
+	err := zippy.repo.Grep(opts, func(m repo.GrepMatch) error {
+		switch {
+		case zippy.output.enabled():
+			matches = append(matches, m)
+		case opts.FilesOnly:
+			fmt.Printf("%s:%s\n", m.Tag, m.Path)
+		default:
+			fmt.Printf("%s:%s:%d:%s\n", m.Tag, m.Path, m.Line, m.Text)
 		}
 		return nil
 	})
-	fmt.Println("\nFiles to be committed:")
-	if len(included) == 0 {
-		fmt.Println("  (none)")
-	} else {
-		for _, f := range included {
-			fmt.Printf("  %s\n", f)
+	if err != nil {
+		return err
+	}
+	return zippy.output.write(matches)
+}
+
+// parseTime reads a date for --since and --until: a date, a date and time,
+// RFC 3339, or a time ago such as 2d. A plain date given as the end of a
+// range includes that whole day.
+func parseTime(text string, end bool) (time.Time, error) {
+	if n, err := strconv.Atoi(text[:len(text)-1]); err == nil && n >= 0 {
+		unit := map[byte]time.Duration{'m': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[text[len(text)-1]]
+		if unit != 0 {
+			return time.Now().Add(-time.Duration(n) * unit), nil
 		}
 	}
-	fmt.Println("\nIgnored files:")
-	if len(ignored) == 0 {
-		fmt.Println("  (none)")
-	} else {
-		for _, f := range ignored {
-			fmt.Printf("  %s\n", f)
-		}
-	}
-	// Advanced: Compare with latest version
-	files, err := os.ReadDir(zippy.versionsPath)
-	if err == nil && len(files) > 0 {
-		// Find latest version by timestamp
-		latestFile := ""
-		latestTime := time.Time{}
-		for _, file := range files {
-			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-				continue
-			}
-			path := filepath.Join(zippy.versionsPath, file.Name())
-			data, err := os.ReadFile(path)
-			if err != nil {
-				continue
-			}
-			var v Version
-			if err := json.Unmarshal(data, &v); err != nil {
-				continue
-			}
-			if v.Timestamp.After(latestTime) {
-				latestTime = v.Timestamp
-				latestFile = file.Name()
-			}
+	if t, err := time.ParseInLocation("2006-01-02", text, time.Local); err == nil {
+		if end {
+			t = t.Add(24*time.Hour - time.Nanosecond)
 		}
-		if latestFile != "" {
-			path := filepath.Join(zippy.versionsPath, latestFile)
-			data, err := os.ReadFile(path)
-			if err == nil {
-				var v Version
-				if err := json.Unmarshal(data, &v); err == nil {
-					zipReader, err := zip.OpenReader(v.ZipPath)
-					if err == nil {
-						defer zipReader.Close()
-						versionFiles := map[string]uint32{}
-						for _, f := range zipReader.File {
-							if !f.FileInfo().IsDir() {
-								versionFiles[f.Name] = f.CRC32
-							}
-						}
-						currentFiles := map[string]uint32{}
-						filepath.Walk(zippy.repoPath, func(path string, info os.FileInfo, err error) error {
-							relPath, _ := filepath.Rel(zippy.repoPath, path)
-							if relPath == "." || strings.HasPrefix(relPath, ".zippy") {
-								return nil
-							}
-							if zippyignore.shouldIgnore(relPath) || info.IsDir() {
-								return nil
-							}
-							crc, _ := fileCRC32(path)
-							currentFiles[relPath] = crc
-							return nil
-						})
-						added, removed, changed := []string{}, []string{}, []string{}
-						for name, crc := range currentFiles {
-							if vcrc, ok := versionFiles[name]; !ok {
-								added = append(added, name)
-							} else if vcrc != crc {
-								changed = append(changed, name)
-							}
-						}
-						for name := range versionFiles {
-							if _, ok := currentFiles[name]; !ok {
-								removed = append(removed, name)
-							}
-						}
-						fmt.Println("\nCompared to latest version:")
-						if len(added) > 0 {
-							fmt.Println("  New files:")
-							for _, f := range added {
-								fmt.Printf("    + %s\n", f)
-							}
-						}
-						if len(removed) > 0 {
-							fmt.Println("  Deleted files:")
-							for _, f := range removed {
-								fmt.Printf("    - %s\n", f)
-							}
-						}
-						if len(changed) > 0 {
-							fmt.Println("  Modified files:")
-							for _, f := range changed {
-								fmt.Printf("    * %s\n", f)
-							}
-						}
-						if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
-							fmt.Println("  No changes since last version.")
-						}
-					}
-				}
-			}
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t, nil
 		}
 	}
+	return time.Time{}, usageError("invalid date %q (use YYYY-MM-DD, YYYY-MM-DD HH:MM, RFC 3339 or a time ago such as 2d)", text)
 }
 
-func (zippy *Zippy) diff(v1, v2 string) {
-	fmt.Printf("Comparing %s with %s...\n", v1, v2)
-	// Load version metadata
-	getZipPath := func(version string) (string, error) {
-		versionFile := filepath.Join(zippy.versionsPath, version+".json")
-		data, err := os.ReadFile(versionFile)
-		if err != nil {
-			return "", err
-		}
-		var v Version
-		if err := json.Unmarshal(data, &v); err != nil {
-			return "", err
+func (zippy *Zippy) restore(version string, restorePath string) error {
+	text := !zippy.output.enabled()
+	if text {
+		fmt.Printf("Restoring version %s", version)
+		if restorePath != "" {
+			fmt.Printf(" (path: %s)", restorePath)
 		}
-		return v.ZipPath, nil
+		fmt.Println("...")
 	}
-	zip1, err1 := getZipPath(v1)
-	zip2, err2 := getZipPath(v2)
-	if err1 != nil || err2 != nil {
-		fmt.Printf("Error loading version metadata.\n")
-		return
+	results, err := zippy.repo.Restore(repo.RestoreOptions{Tag: version, Path: restorePath})
+	if results == nil {
+		return err
 	}
-	files1 := make(map[string]uint32)
-	files2 := make(map[string]uint32)
-	readZip := func(zipPath string, files map[string]uint32) error {
-		zr, err := zip.OpenReader(zipPath)
-		if err != nil {
-			return err
-		}
-		defer zr.Close()
-		for _, f := range zr.File {
-			if !f.FileInfo().IsDir() {
-				files[f.Name] = f.CRC32
+	if text {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("  [Error restoring %s: %s]\n", r.Path, r.Error)
+			} else {
+				fmt.Printf("  Restored: %s\n", r.Path)
 			}
 		}
-		return nil
 	}
-	if err := readZip(zip1, files1); err != nil {
-		fmt.Printf("Error reading %s: %v\n", v1, err)
-		return
+	if werr := zippy.output.write(results); werr != nil {
+		return werr
 	}
-	if err := readZip(zip2, files2); err != nil {
-		fmt.Printf("Error reading %s: %v\n", v2, err)
-		return
+	if err != nil {
+		return err
 	}
-	added, removed, changed := []string{}, []string{}, []string{}
-	for name, crc := range files2 {
-		if crc1, ok := files1[name]; !ok {
-			added = append(added, name)
-		} else if crc1 != crc {
-			changed = append(changed, name)
-		}
+	if len(results) > 0 && text {
+		fmt.Println("Restore complete.")
 	}
-	for name := range files1 {
-		if _, ok := files2[name]; !ok {
-			removed = append(removed, name)
-		}
+	return nil
+}
+
+func (zippy *Zippy) status() error {
+	result, err := zippy.repo.Status()
+	if err != nil {
+		return err
 	}
-	if len(added) > 0 {
-		fmt.Println("Added files:")
-		for _, f := range added {
-			fmt.Printf("  + %s\n", f)
-		}
+	if zippy.output.enabled() {
+		return zippy.output.write(result)
 	}
-	if len(removed) > 0 {
-		fmt.Println("Removed files:")
-		for _, f := range removed {
-			fmt.Printf("  - %s\n", f)
-		}
+	fmt.Println("Zippy repository status:")
+	fmt.Println("\nFiles to be committed:")
+	if len(result.Files) == 0 {
+		fmt.Println("  (none)")
 	}
-	if len(changed) > 0 {
-		fmt.Println("Changed files:")
-		for _, f := range changed {
-			fmt.Printf("  * %s\n", f)
-		}
+	for _, f := range result.Files {
+		fmt.Printf("  %s\n", f)
 	}
-	if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
-		fmt.Println("No differences found.")
+	fmt.Println("\nIgnored files:")
+	if len(result.Ignored) == 0 {
+		fmt.Println("  (none)")
 	}
+	for _, f := range result.Ignored {
+		fmt.Printf("  %s\n", f)
+	}
+	if result.Changes != nil {
+		fmt.Println("\nCompared to latest version:")
+		printChanges(result.Changes, "  ", "New files:", "Deleted files:", "Modified files:")
+		if result.Changes.Empty() {
+			fmt.Println("  No changes since last version.")
+		}
+	}
+	return nil
 }
 
-func (zippy *Zippy) saveVersionInfo(version Version) {
-	// Save version metadata to JSON file
-	versionFile := filepath.Join(zippy.versionsPath, version.Tag+".json")
-	data, _ := json.MarshalIndent(version, "", "  ")
-	os.WriteFile(versionFile, data, 0644)
-}
-
-// Add patchVersion method to Zippy
-func (zippy *Zippy) patchVersion(version string, addPath string) {
-	fmt.Printf("Patching version %s with %s...\n", version, addPath)
-	versionFile := filepath.Join(zippy.versionsPath, version+".json")
-	data, err := os.ReadFile(versionFile)
-	if err != nil {
-		fmt.Printf("Version metadata not found: %v\n", err)
-		return
-	}
-	var v Version
-	if err := json.Unmarshal(data, &v); err != nil {
-		fmt.Printf("Corrupt version metadata: %v\n", err)
-		return
+func (zippy *Zippy) diff(v1, v2 string) error {
+	if !zippy.output.enabled() {
+		fmt.Printf("Comparing %s with %s...\n", v1, v2)
 	}
-	zipPath := v.ZipPath
-	// Create temp dir
-	tempDir, err := os.MkdirTemp("", "zippy_patch_*")
+	result, err := zippy.repo.Diff(v1, v2)
 	if err != nil {
-		fmt.Printf("Error creating temp dir: %v\n", err)
-		return
+		return err
 	}
-	defer os.RemoveAll(tempDir)
-	// Extract zip to temp dir
-	zipReader, err := zip.OpenReader(zipPath)
-	if err != nil {
-		fmt.Printf("Error opening zip: %v\n", err)
-		return
+	if zippy.output.enabled() {
+		return zippy.output.write(result)
 	}
-	for _, f := range zipReader.File {
-		outPath := filepath.Join(tempDir, f.Name)
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(outPath, 0755)
-			continue
-		}
-		os.MkdirAll(filepath.Dir(outPath), 0755)
-		outFile, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			continue
-		}
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			continue
-		}
-		io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+	printChanges(&result.Changes, "", "Added files:", "Removed files:", "Changed files:")
+	if result.Empty() {
+		fmt.Println("No differences found.")
 	}
-	zipReader.Close()
-	// Copy new file/folder into temp dir
-	srcPath := filepath.Join(zippy.repoPath, addPath)
-	info, err := os.Stat(srcPath)
-	if err != nil {
-		fmt.Printf("File/folder to add not found: %v\n", err)
-		return
+	return nil
+}
+
+func (zippy *Zippy) listRemotes() error {
+	remotes := zippy.repo.Remotes()
+	if len(remotes) == 0 {
+		fmt.Println("No remotes. Add one with 'zippy remote add <name> <path>'.")
+		return nil
 	}
-	var copyErr error
-	if info.IsDir() {
-		copyErr = copyDir(srcPath, filepath.Join(tempDir, addPath))
-	} else {
-		os.MkdirAll(filepath.Dir(filepath.Join(tempDir, addPath)), 0755)
-		copyErr = copyFile(srcPath, filepath.Join(tempDir, addPath))
+	for _, r := range remotes {
+		fmt.Printf("  %s | %s\n", r.Name, r.URL)
 	}
-	if copyErr != nil {
-		fmt.Printf("Error copying file/folder: %v\n", copyErr)
-		return
+	return nil
+}
+
+// settings returns the merged settings of the repository, or those of the
+// user config alone
+func (zippy *Zippy) settings(global bool) ([]repo.Setting, error) {
+	if global {
+		return repo.UserSettings()
 	}
-	// Re-zip everything in temp dir
-	newZip, err := os.Create(zipPath)
-	if err != nil {
-		fmt.Printf("Error creating zip: %v\n", err)
-		return
+	return zippy.repo.Settings(), nil
+}
+
+func (zippy *Zippy) getSetting(settings []repo.Setting, key string) error {
+	if !slices.Contains(repo.ConfigKeys(), key) {
+		return usageError("unknown config key %q (use one of %s)", key, strings.Join(repo.ConfigKeys(), ", "))
 	}
-	writer := zip.NewWriter(newZip)
-	fileCount := 0
-	filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		rel, _ := filepath.Rel(tempDir, path)
-		if rel == "." {
-			return nil
-		}
-		if info.IsDir() {
+	for _, setting := range settings {
+		if setting.Key == key {
+			fmt.Println(setting.Value)
 			return nil
 		}
-		f, err := writer.Create(rel)
-		if err != nil {
-			return err
-		}
-		in, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(f, in)
-		in.Close()
-		if err != nil {
-			return err
-		}
-		fileCount++
-		return nil
-	})
-	writer.Close()
-	newZip.Close()
-	// Update version metadata
-	if stat, err := os.Stat(zipPath); err == nil {
-		v.Size = stat.Size()
-	}
-	v.FilesCount = fileCount
-	zippy.saveVersionInfo(v)
-	fmt.Println("Patch complete.")
+	}
+	return notFoundError("%s is not set", key)
 }
-// Helper functions for copying files and directories
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+
+func (zippy *Zippy) listSettings(settings []repo.Setting) error {
+	if zippy.output.enabled() {
+		return zippy.output.write(settings)
 	}
-	defer in.Close()
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
+	if len(settings) == 0 {
+		fmt.Println("No settings. Change one with 'zippy config set <key> <value>'.")
 	}
-	defer out.Close()
-	_, err = io.Copy(out, in)
-	return err
+	for _, setting := range settings {
+		fmt.Printf("  %-4s | %s=%s\n", setting.Scope, setting.Key, setting.Value)
+	}
+	return nil
 }
-func copyDir(src, dst string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+
+func (zippy *Zippy) verify(tags []string, signatures bool) error {
+	if signatures && len(zippy.repo.Config().TrustedKeys) == 0 {
+		fmt.Fprintln(os.Stderr, "Warning: no trusted keys. Add one with 'zippy key trust <public-key> <name>'.")
+	}
+	return zippy.repo.Verify(tags, signatures)
+}
+
+func showBanner() {
+	fmt.Printf(`
+ ███████╗██╗██████╗ ██████╗ ██╗   ██╗
+ ╚══███╔╝██║██╔══██╗██╔══██╗╚██╗ ██╔╝
+   ███╔╝ ██║██████╔╝██████╔╝ ╚████╔╝ 
+  ███╔╝  ██║██╔═══╝ ██╔═══╝   ╚██╔╝  
+ ███████╗██║██║     ██║        ██║   
+ ╚══════╝╚═╝╚═╝     ╚═╝        ╚═╝   
+
+%s v%s - %s
+%s
+
+`, ZIPPY_NAME, ZippyVersion, ZIPPY_DESC, ZIPPY_REPO)
+}
+
+func showVersion() {
+	fmt.Printf("%s version %s\n", ZIPPY_NAME, ZippyVersion)
+	fmt.Printf("Build: %s\n", time.Now().Format("2006-01-02"))
+	fmt.Printf("Author: %s\n", ZippyAuthor)
+}
+
+func showAbout() {
+	showBanner()
+	fmt.Printf("About %s:\n", ZIPPY_NAME)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("Version: %s\n", ZippyVersion)
+	fmt.Printf("Description: %s\n", ZIPPY_DESC)
+	fmt.Printf("Author: %s\n", ZippyAuthor)
+	fmt.Printf("Repository: %s\n", ZIPPY_REPO)
+	fmt.Println()
+	fmt.Println("Features:")
+	fmt.Println("  • Simple version control with zip storage")
+	fmt.Println("  • .zippyignore support (like .gitignore)")
+	fmt.Println("  • Human-readable version tags")
+	fmt.Println("  • Easy backup and sharing")
+	fmt.Println("  • Cross-platform compatibility")
+	fmt.Println()
+	fmt.Println("For more information, visit:", ZIPPY_REPO)
+}
+
+func showHelp() {
+	help := `
+Zippy - Simple Version Control Tool with Zip Storage
+
+USAGE:
+  zippy [-C <dir>] <command> [options] [arguments]
+
+COMMANDS:
+  init [dir]                Create a repository in the current or given folder
+  add <paths...>            Stage files or folders for the next commit
+  commit -m <msg> -t <tag>  Create a version from the staged files
+  status                    Show staged, ignored and changed files
+  list, ls                  List all versions
+  log                       Show versions newest first, filtered by date, author, message or path
+  show <version>            Show a version's details, changes and files
+  cat <version> <path>      Print a file from a version
+  history <path>            Show the versions that changed a file
+  grep <regexp> [versions]  Search the files of versions
+  diff <v1> <v2>            Show files added, removed or changed between versions
+  restore <version> [path]  Restore a version, or one file or folder of it
+  patch <version> <path>    Add a file or folder to an existing version
+  verify [versions...]      Check archives and signatures
+  gc                        Remove unreferenced archives and pack small ones
+  remote                    List, add and remove remotes
+  push <remote>             Send versions to a remote
+  pull <remote>             Fetch versions from a remote
+  clone <source> <dir>      Create a working copy from a repository
+  serve                     Serve this repository over HTTP
+  bundle                    Create, verify and import bundle files
+  import git|archive        Create versions from git history or release archives
+  export git <dir>          Write every version as a git commit
+  key                       Manage signing keys and rotate the encryption key
+  config                    Read and change repository and user settings
+  version, --version        Show Zippy version information
+  help [command]            Show this help, or the options of a command
+  about, info               Show detailed information about Zippy
+
+Run 'zippy help <command>' for the options of a command, for example
+'zippy help commit' or 'zippy help key rotate'. Options can be given before
+or after arguments; '--' ends the options, so 'zippy add -- -file' stages a
+file whose name starts with '-'.
+
+Commands work from any folder inside a repository. -C <dir> runs as if
+started in <dir>, and ZIPPY_DIR names the repository folder to use instead of
+searching. Paths given to add, restore and patch are relative to the current
+folder.
+
+'list', 'log', 'show', 'history', 'grep', 'status', 'diff', 'restore' and
+'config list' accept --json for JSON output and --format <template> for Go
+template output, before or after the command, for example 'zippy --json list' or "zippy list --format '{{.Tag}}'".
+
+FILES:
+  .zippyignore
+      List files and patterns to ignore (like .gitignore).
+  .zippy/
+      Zippy metadata directory (do not delete or edit manually).
+
+EXIT STATUS:
+  0  success                  4  version, file, remote or key not found
+  1  other error              5  already exists, or versions conflict
+  2  usage error              6  reading or writing files, storage or network failed
+  3  not a Zippy repository
+  Errors and warnings are written to stderr.
+
+WORKFLOW EXAMPLES:
+  zippy init
+  zippy add .
+  zippy commit -m "Initial commit" -t v1.0
+  zippy list
+  zippy status
+  zippy restore v1.0 src/main.go
+  zippy patch v1.0 README.md
+  zippy diff v1.0 v2.0
+
+TIPS:
+- Use 'zippy add' before every commit to stage files.
+- Edit .zippyignore to avoid archiving unwanted files.
+- Use 'zippy status' to see what will be committed and what changed.
+- Each commit creates a zip in .zippy/storage and a metadata file in .zippy/versions.
+- Set ZIPPY_PASSPHRASE (or ZIPPY_NEW_PASSPHRASE for 'key rotate') to use an
+  encrypted repository from scripts. ZIPPY_KEYFILE overrides the key file path.
+
+For more information: %s
+`
+	fmt.Printf(help, ZIPPY_REPO)
+}
+
+// printChanges lists added, removed and changed files under their headings
+func printChanges(changes *repo.Changes, indent string, added string, removed string, changed string) {
+	for _, group := range []struct {
+		heading string
+		mark    string
+		files   []string
+	}{{added, "+", changes.Added}, {removed, "-", changes.Removed}, {changed, "*", changes.Changed}} {
+		if len(group.files) == 0 {
+			continue
 		}
-		rel, _ := filepath.Rel(src, path)
-		target := filepath.Join(dst, rel)
-		if info.IsDir() {
-			return os.MkdirAll(target, 0755)
+		fmt.Println(indent + group.heading)
+		for _, f := range group.files {
+			fmt.Printf("%s  %s %s\n", indent, group.mark, f)
 		}
-		return copyFile(path, target)
-	})
-}
-// Helper to get CRC32 of a file
-func fileCRC32(path string) (uint32, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	h := crc32.NewIEEE()
-	_, err = io.Copy(h, f)
-	if err != nil {
-		return 0, err
 	}
-	return h.Sum32(), nil
-}
\ No newline at end of file
+}
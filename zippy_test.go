@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		text string
+		end  bool
+		want time.Time
+		ago  time.Duration // for a time ago, instead of want
+		ok   bool
+	}{
+		{text: "2024-03-05", want: day, ok: true},
+		{text: "2024-03-05", end: true, want: day.Add(24*time.Hour - time.Nanosecond), ok: true},
+		{text: "2024-03-05 14:30", end: true, want: day.Add(14*time.Hour + 30*time.Minute), ok: true},
+		{text: "2024-03-05 14:30:15", want: day.Add(14*time.Hour + 30*time.Minute + 15*time.Second), ok: true},
+		{text: "2024-03-05T14:30:00Z", want: time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC), ok: true},
+		{text: "30m", ago: 30 * time.Minute, ok: true},
+		{text: "2h", ago: 2 * time.Hour, ok: true},
+		{text: "2d", ago: 48 * time.Hour, ok: true},
+		{text: "1w", ago: 7 * 24 * time.Hour, ok: true},
+		{text: "0d", ago: 0, ok: true},
+		{text: "2y"},
+		{text: "-1d"},
+		{text: "d"},
+		{text: "yesterday"},
+		{text: "2024-13-01"},
+		{text: "05/03/2024"},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		got, err := parseTime(tt.text, tt.end)
+		after := time.Now()
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("parseTime(%q) = %v, want an error", tt.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTime(%q): %v", tt.text, err)
+			continue
+		}
+		if tt.want.IsZero() {
+			if got.Before(before.Add(-tt.ago)) || got.After(after.Add(-tt.ago)) {
+				t.Errorf("parseTime(%q) = %v, want %v ago", tt.text, got, tt.ago)
+			}
+		} else if !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q, %v) = %v, want %v", tt.text, tt.end, got, tt.want)
+		}
+	}
+}